@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	ulp "github.com/n0madic/go-ulp"
+)
+
+// followStats holds the counters reported by -verbose in follow mode.
+type followStats struct {
+	lines     int
+	templates int // new templates
+	changes   int // refined templates
+}
+
+// follow classifies the lines appended to path from offset on until ctx is
+// done. The parser must have been created with changes.notify as its
+// template change callback. With templatesOnly only template changes are
+// written to w; otherwise w receives the events and template changes go to
+// stderr.
+func follow(ctx context.Context, w io.Writer, parser *ulp.Parser, path string, offset int64, format string, templatesOnly bool, changes *changeNotifier) (followStats, error) {
+	var stats followStats
+	var cw changeWriter = &textChangeWriter{w: os.Stderr, prefix: true}
+	if templatesOnly {
+		var err error
+		if cw, err = newChangeWriter(w, format); err != nil {
+			return stats, err
+		}
+	}
+	changes.fn = func(c ulp.TemplateChange) error {
+		if c.OldTemplate == "" {
+			stats.templates++
+		} else {
+			stats.changes++
+		}
+		return cw.write(c)
+	}
+
+	var ew eventWriter
+	if !templatesOnly {
+		var err error
+		if ew, err = newEventWriter(w, format, parser.Snapshot().HeaderFields, false); err != nil {
+			return stats, err
+		}
+	}
+	for line, err := range ulp.Follow(ctx, path, offset) {
+		if errors.Is(err, bufio.ErrTooLong) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.lines++
+		ev := parser.Add(line)
+		if changes.err != nil {
+			return stats, changes.err
+		}
+		if ev == nil || ew == nil {
+			continue
+		}
+		if err := ew.write(ev); err != nil {
+			return stats, err
+		}
+		if err := ew.flush(); err != nil {
+			return stats, err
+		}
+	}
+	if ew != nil {
+		return stats, ew.close()
+	}
+	return stats, nil
+}
+
+// changeNotifier forwards the template changes of the online mode to fn,
+// which is set once the output is ready, keeping the first error.
+type changeNotifier struct {
+	fn  func(ulp.TemplateChange) error
+	err error
+}
+
+func (n *changeNotifier) notify(c ulp.TemplateChange) {
+	if n.fn != nil && n.err == nil {
+		n.err = n.fn(c)
+	}
+}
+
+// changeWriter writes template changes one at a time as they happen.
+type changeWriter interface {
+	write(c ulp.TemplateChange) error
+}
+
+// newChangeWriter creates a changeWriter for the given output format.
+// JSON output has one object per line, since it never ends.
+func newChangeWriter(w io.Writer, format string) (changeWriter, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"EventID", "OldTemplate", "Template"}); err != nil {
+			return nil, err
+		}
+		cw.Flush()
+		return &csvChangeWriter{cw: cw}, cw.Error()
+	case "json":
+		return &jsonChangeWriter{enc: json.NewEncoder(w)}, nil
+	case "text":
+		return &textChangeWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+type csvChangeWriter struct {
+	cw *csv.Writer
+}
+
+func (c *csvChangeWriter) write(tc ulp.TemplateChange) error {
+	if err := c.cw.Write([]string{tc.EventID, tc.OldTemplate, tc.NewTemplate}); err != nil {
+		return err
+	}
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+type templateChangeJSON struct {
+	EventID     string `json:"event_id"`
+	OldTemplate string `json:"old_template,omitempty"`
+	Template    string `json:"template"`
+}
+
+type jsonChangeWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonChangeWriter) write(tc ulp.TemplateChange) error {
+	return j.enc.Encode(templateChangeJSON{
+		EventID:     tc.EventID,
+		OldTemplate: tc.OldTemplate,
+		Template:    tc.NewTemplate,
+	})
+}
+
+// textChangeWriter writes "new" and "changed" lines; prefix marks them as
+// template changes when they are mixed with other output on stderr.
+type textChangeWriter struct {
+	w      io.Writer
+	prefix bool
+}
+
+func (t *textChangeWriter) write(tc ulp.TemplateChange) error {
+	prefix := ""
+	if t.prefix {
+		prefix = "template "
+	}
+	if tc.OldTemplate == "" {
+		_, err := fmt.Fprintf(t.w, "%snew\t%s\n", prefix, tc.NewTemplate)
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "%schanged\t%s\t(was %s)\n", prefix, tc.NewTemplate, tc.OldTemplate)
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ulp "github.com/n0madic/go-ulp"
+)
+
+func TestFollowPartialLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	lines := "2024-01-15T10:30:22.000000001Z stdout P upload of \n" +
+		"2024-01-15T10:30:22.000000002Z stdout F 42 bytes done\n"
+	if err := os.WriteFile(path, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := &changeNotifier{}
+	parser, err := ulp.New(ulp.WithPreset("cri"), ulp.WithTemplateChange(changes.notify))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var out strings.Builder
+	stats, err := follow(ctx, &out, parser, path, 0, "json", false, changes)
+	if err != nil {
+		t.Fatalf("follow() error = %v", err)
+	}
+	if stats.lines != 2 || stats.templates != 1 {
+		t.Errorf("stats = %+v, want 2 lines and 1 template", stats)
+	}
+	if got := strings.Count(out.String(), `"content": `); got != 1 {
+		t.Errorf("got %d events, want 1:\n%s", got, out.String())
+	}
+	if !strings.Contains(out.String(), `"content": "upload of 42 bytes done"`) {
+		t.Errorf("output lacks the joined message:\n%s", out.String())
+	}
+}
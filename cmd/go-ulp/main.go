@@ -1,36 +1,106 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 
 	ulp "github.com/n0madic/go-ulp"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run parses the command line and does the work, so that deferred calls
+// such as flushing the rejects file happen before main exits on an error.
+func run() (err error) {
 	headerFormat := flag.String("header-format", "", `Log header format (e.g., "<Date> <Time> <Level> <Content>")`)
+	headerRegex := flag.String("header-regex", "", `Log header regex with named groups (e.g., "^(?P<Level>\w+): (?P<Content>.*)$"); "auto" converts -header-format`)
 	contentField := flag.String("content-field", "Content", "Header field with log message")
+	preset := flag.String("preset", "", `Built-in log format preset, e.g. HDFS or syslog-rfc3164 (see "go-ulp presets")`)
+	inputFormat := flag.String("input-format", "text", "Input format: text (see -header-format), json, logfmt, docker, cri")
+	messageField := flag.String("message-field", "msg", "Message field for -input-format json (dotted path) or logfmt")
+	foldKeys := flag.Bool("fold-keys", false, "Append the other fields of json/logfmt input to the content as key=<*>")
+	headerMismatch := flag.String("header-mismatch", "keep", "Lines not matching the header format: keep, whole-line (use the whole line as content) or skip")
+	rejects := flag.String("rejects", "", "Write lines not matching the header format to this file")
 	regexStr := flag.String("regex", "", "Additional regex patterns, comma-separated")
+	patternFile := flag.String("patterns", "", "JSON pattern pack file with additional preprocessing patterns")
+	extendedPatterns := flag.String("extended-patterns", "", `Extended built-in patterns to turn on, comma-separated, e.g. ipv4,duration, or "all" (see "go-ulp patterns")`)
+	disablePatterns := flag.String("disable-patterns", "", `Built-in patterns to turn off, comma-separated, e.g. host,url (see "go-ulp patterns")`)
+	punctuation := flag.String("punctuation", "!@#$%^&{}<>?\\|`~", "Characters removed from the content before tokenizing; empty keeps all")
+	brackets := flag.String("brackets", "=()[]", "Characters split off as tokens of their own; empty keeps them attached")
+	typed := flag.Bool("typed-placeholders", false, "Replace regex-matched tokens with typed placeholders such as <IP> or <DATE> instead of <*>")
 	sampleSize := flag.Int("sample-size", 0, "Max events sampled per group, 0=all")
 	workers := flag.Int("workers", 0, "Worker goroutines, 0=auto")
 	format := flag.String("format", "csv", "Output format: csv, json, text")
 	templatesOnly := flag.Bool("templates-only", false, "Output only unique templates")
 	output := flag.String("output", "", "Output file (default stdout)")
-	verbose := flag.Bool("verbose", false, "Show parsing statistics to stderr")
+	verbose := flag.Bool("verbose", false, "Show progress and parsing statistics to stderr")
+	progressMode := flag.String("progress", "auto", "Progress on stderr: auto (when stderr is a terminal or with -verbose), on or off")
+	modelPath := flag.String("model", "", "Classify input with templates from a saved model instead of learning new ones")
+	saveModel := flag.String("save-model", "", "Save the learned templates and parser options to a model file")
+	multiline := flag.Bool("multiline", false, "Join continuation lines (e.g. stack traces) to the preceding line matching -header-regex or the input format; see -multiline-start")
+	multilineStart := flag.String("multiline-start", "", "Regex matching the first line of a multi-line event (implies -multiline)")
+	multilineMaxLines := flag.Int("multiline-max-lines", 500, "Max lines kept per multi-line event")
+	multilineMaxBytes := flag.Int("multiline-max-bytes", 64*1024, "Max bytes kept per multi-line event")
+	stream := flag.Bool("stream", false, "Bounded-memory mode: read INPUT_FILE twice instead of keeping all events in memory")
+	followMode := flag.Bool("follow", false, "After parsing INPUT_FILE, keep reading lines appended to it like tail -f, printing new templates as they appear")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: go-ulp [flags] [INPUT_FILE]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: go-ulp [flags] [INPUT_FILE...]\n")
+		fmt.Fprintf(os.Stderr, "       go-ulp presets\n")
+		fmt.Fprintf(os.Stderr, "       go-ulp patterns\n\n")
 		fmt.Fprintf(os.Stderr, "ULP (Unified Log Parser) extracts log templates from unstructured log files.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nIf no INPUT_FILE is specified, reads from stdin.\n")
+		fmt.Fprintf(os.Stderr, "\nIf no INPUT_FILE is specified, reads from stdin. INPUT_FILE may also be a\n")
+		fmt.Fprintf(os.Stderr, "directory or a glob pattern (quoted, \"**\" matches any number of directories);\n")
+		fmt.Fprintf(os.Stderr, "several files are parsed together with a per-file breakdown of template counts.\n")
 	}
 
+	if len(os.Args) == 2 && os.Args[1] == "presets" {
+		listPresets(os.Stdout)
+		return nil
+	}
+	if len(os.Args) == 2 && os.Args[1] == "patterns" {
+		listPatterns(os.Stdout)
+		return nil
+	}
 	flag.Parse()
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	args := flag.Args()
+	if *stream && len(args) == 0 {
+		return errors.New("-stream requires an INPUT_FILE, stdin cannot be read twice")
+	}
+	if *stream && *modelPath != "" {
+		return errors.New("-stream cannot be combined with -model")
+	}
+	files, err := ulp.ExpandPaths(args...)
+	if err != nil {
+		return fmt.Errorf("Error opening input file: %w", err)
+	}
+	if len(files) > 1 && (*stream || *modelPath != "") {
+		return errors.New("-stream and -model require a single INPUT_FILE")
+	}
+	if *followMode && (len(files) != 1 || *stream || *multiline || *multilineStart != "") {
+		return errors.New("-follow requires a single INPUT_FILE and cannot be combined with -stream or -multiline")
+	}
 
 	// Build parser options
 	var opts []ulp.Option
@@ -38,9 +108,52 @@ func main() {
 	if *contentField != "Content" {
 		opts = append(opts, ulp.WithContentField(*contentField))
 	}
-	if *headerFormat != "" {
+	switch {
+	case *headerRegex == "auto":
+		pattern, err := ulp.HeaderFormatRegex(*headerFormat)
+		if err != nil {
+			return fmt.Errorf("Error converting header format: %w", err)
+		}
+		opts = append(opts, ulp.WithHeaderRegex(pattern))
+	case *headerRegex != "":
+		opts = append(opts, ulp.WithHeaderRegex(*headerRegex))
+	case *headerFormat != "":
 		opts = append(opts, ulp.WithHeaderFormat(*headerFormat))
 	}
+	if *preset != "" {
+		opts = append(opts, ulp.WithPreset(*preset))
+	}
+	switch *inputFormat {
+	case "text":
+	case "json":
+		opts = append(opts, ulp.WithJSONInput(*messageField))
+	case "logfmt":
+		opts = append(opts, ulp.WithLogfmtInput(*messageField))
+	case "docker", "cri":
+		opts = append(opts, ulp.WithPreset(*inputFormat))
+	default:
+		return fmt.Errorf("Unknown -input-format %q", *inputFormat)
+	}
+	if *foldKeys {
+		opts = append(opts, ulp.WithFoldKeys(true))
+	}
+	var showProgress bool
+	switch *progressMode {
+	case "auto":
+		showProgress = *verbose || isTerminal(os.Stderr)
+	case "on":
+		showProgress = true
+	case "off":
+	default:
+		return fmt.Errorf("Unknown -progress %q", *progressMode)
+	}
+	policy, err := ulp.ParseHeaderMismatchPolicy(*headerMismatch)
+	if err != nil {
+		return fmt.Errorf("Invalid -header-mismatch: %w", err)
+	}
+	if policy != ulp.HeaderMismatchKeep {
+		opts = append(opts, ulp.WithHeaderMismatch(policy))
+	}
 	if *regexStr != "" {
 		patterns := strings.Split(*regexStr, ",")
 		for i := range patterns {
@@ -48,35 +161,131 @@ func main() {
 		}
 		opts = append(opts, ulp.WithCustomRegex(patterns))
 	}
+	if *patternFile != "" {
+		opts = append(opts, ulp.WithPatternFile(*patternFile))
+	}
+	switch *extendedPatterns {
+	case "":
+	case "all":
+		opts = append(opts, ulp.WithExtendedPatterns())
+	default:
+		opts = append(opts, ulp.WithExtendedPatterns(splitNames(*extendedPatterns)...))
+	}
+	if *disablePatterns != "" {
+		opts = append(opts, ulp.WithDisabledPatterns(splitNames(*disablePatterns)...))
+	}
+	if *typed {
+		opts = append(opts, ulp.WithTypedPlaceholders(true))
+	}
+	if set["punctuation"] {
+		opts = append(opts, ulp.WithPunctuation(*punctuation))
+	}
+	if set["brackets"] {
+		opts = append(opts, ulp.WithBrackets(*brackets))
+	}
+	if *multiline || *multilineStart != "" {
+		opts = append(opts,
+			ulp.WithMultiline(*multilineStart),
+			ulp.WithMultilineLimits(*multilineMaxLines, *multilineMaxBytes),
+		)
+	}
 	if *sampleSize > 0 {
 		opts = append(opts, ulp.WithSampleSize(*sampleSize))
 	}
+
+	// Runtime options apply to models as well
+	var runtimeOpts []ulp.Option
 	if *workers > 0 {
-		opts = append(opts, ulp.WithMaxWorkers(*workers))
+		runtimeOpts = append(runtimeOpts, ulp.WithMaxWorkers(*workers))
 	}
-
-	parser, err := ulp.New(opts...)
-	if err != nil {
-		log.Fatalf("Error creating parser: %v", err)
+	if *templatesOnly {
+		runtimeOpts = append(runtimeOpts, ulp.WithParameters(false))
+	}
+	changes := &changeNotifier{}
+	if *followMode {
+		runtimeOpts = append(runtimeOpts, ulp.WithTemplateChange(changes.notify))
 	}
-
 	// Determine input source
-	var input *os.File
-	args := flag.Args()
-	if len(args) > 0 {
-		input, err = os.Open(args[0])
+	var input io.Reader = os.Stdin
+	var inFile *inputFile
+	var progress *progressPrinter
+	switch {
+	case len(files) > 1:
+		// Files are opened by the parser, only lines are counted
+		if showProgress {
+			progress = newProgressPrinter(os.Stderr, 0, nil)
+			runtimeOpts = append(runtimeOpts, ulp.WithProgress(progress.report))
+		}
+	case len(files) == 1:
+		in, err := openInput(files[0])
+		if err != nil {
+			return fmt.Errorf("Error opening input file: %w", err)
+		}
+		defer in.Close()
+		input, inFile = in, in
+		if fi, err := in.f.Stat(); showProgress && err == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+			progress = newProgressPrinter(os.Stderr, fi.Size(), in.counter.n.Load)
+			runtimeOpts = append(runtimeOpts, ulp.WithProgress(progress.report))
+		}
+	}
+	if *rejects != "" {
+		f, err := os.Create(*rejects)
+		if err != nil {
+			return fmt.Errorf("Error creating rejects file: %w", err)
+		}
+		defer f.Close()
+		rw := bufio.NewWriter(f)
+		defer func() {
+			if ferr := rw.Flush(); err == nil {
+				err = ferr
+			}
+		}()
+		runtimeOpts = append(runtimeOpts, ulp.WithRejectWriter(rw))
+	}
+	opts = append(opts, runtimeOpts...)
+
+	var (
+		parser    *ulp.Parser
+		templates []*ulp.LogTemplate
+	)
+	if *modelPath != "" {
+		// Parser options come from the model; only runtime options apply
+		parser, templates, err = loadModel(*modelPath, runtimeOpts...)
 		if err != nil {
-			log.Fatalf("Error opening input file: %v", err)
+			return fmt.Errorf("Error loading model: %w", err)
 		}
-		defer input.Close()
 	} else {
-		input = os.Stdin
+		parser, err = ulp.New(opts...)
+		if err != nil {
+			return fmt.Errorf("Error creating parser: %w", err)
+		}
 	}
 
-	// Parse
-	result, err := parser.Parse(input)
+	// Parse, or classify against the model's templates
+	var (
+		result  *ulp.ParseResult
+		matcher *ulp.Matcher
+	)
+	switch {
+	case *modelPath != "":
+		matcher = ulp.NewMatcher(parser, templates)
+		result, err = matcher.MatchAll(input)
+	case len(files) > 1:
+		result, err = parser.ParseFiles(files...)
+	case *stream:
+		result, err = parser.ParseStream(input)
+	default:
+		result, err = parser.Parse(input)
+	}
+	progress.finish()
 	if err != nil {
-		log.Fatalf("Error parsing: %v", err)
+		return fmt.Errorf("Error parsing: %w", err)
+	}
+
+	if *saveModel != "" {
+		if err := writeModel(*saveModel, parser, result.Templates); err != nil {
+			return fmt.Errorf("Error saving model: %w", err)
+		}
 	}
 
 	// Determine output destination
@@ -84,33 +293,205 @@ func main() {
 	if *output != "" {
 		out, err = os.Create(*output)
 		if err != nil {
-			log.Fatalf("Error creating output file: %v", err)
+			return fmt.Errorf("Error creating output file: %w", err)
 		}
 		defer out.Close()
 	} else {
 		out = os.Stdout
 	}
 
+	if *followMode {
+		// Known templates come from the model, or were learned from the
+		// existing content; follow from where reading stopped
+		if templates == nil {
+			templates = result.Templates
+		}
+		parser.Seed(templates)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Following %s with %d known templates\n", files[0], len(templates))
+		}
+		stats, err := follow(ctx, out, parser, files[0], inFile.counter.n.Load(), *format, *templatesOnly, changes)
+		if err != nil {
+			return fmt.Errorf("Error following input: %w", err)
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Lines:     %d\n", stats.lines)
+			fmt.Fprintf(os.Stderr, "New:       %d\n", stats.templates)
+			fmt.Fprintf(os.Stderr, "Changed:   %d\n", stats.changes)
+		}
+		return nil
+	}
+
 	// Sort templates by frequency (descending)
 	sort.Slice(result.Templates, func(i, j int) bool {
 		return result.Templates[i].Count > result.Templates[j].Count
 	})
 
 	// Write output
-	if *templatesOnly {
+	lines := len(result.Events)
+	switch {
+	case *templatesOnly:
 		err = writeTemplates(out, result, *format)
-	} else {
+	case *stream:
+		lines, err = writeStreamEvents(out, parser, files[0], result, *format, progress)
+	default:
 		err = writeEvents(out, result, *format)
 	}
+	progress.finish()
 	if err != nil {
-		log.Fatalf("Error writing output: %v", err)
+		return fmt.Errorf("Error writing output: %w", err)
 	}
 
 	// Verbose stats to stderr
 	if *verbose {
-		fmt.Fprintf(os.Stderr, "Lines:     %d\n", len(result.Events))
+		if len(result.Sources) > 1 {
+			fmt.Fprintf(os.Stderr, "Files:     %d\n", len(result.Sources))
+		}
+		fmt.Fprintf(os.Stderr, "Lines:     %d\n", lines)
 		fmt.Fprintf(os.Stderr, "Templates: %d\n", len(result.Templates))
 		fmt.Fprintf(os.Stderr, "Groups:    %d\n", len(result.Groups))
+		if result.Stats.HeaderMismatches > 0 {
+			fmt.Fprintf(os.Stderr, "Mismatch:  %d\n", result.Stats.HeaderMismatches)
+		}
+		if result.Stats.Skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped:   %d\n", result.Stats.Skipped)
+		}
+		if matcher != nil {
+			fmt.Fprintf(os.Stderr, "Unmatched: %d\n", matcher.Stats().Unmatched)
+		}
 		fmt.Fprintf(os.Stderr, "Duration:  %v\n", result.Duration)
 	}
+	return nil
+}
+
+// listPresets writes the built-in presets with their formats.
+func listPresets(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDESCRIPTION\tFORMAT")
+	for _, p := range ulp.Presets {
+		format := p.Format
+		if format == "" {
+			format = "(built-in parser)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Name, p.Description, format)
+	}
+	tw.Flush()
+}
+
+// listPatterns writes the built-in preprocessing patterns, the default ones
+// followed by the extended ones turned on with -extended-patterns.
+func listPatterns(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSET\tPLACEHOLDER\tREGEX")
+	for _, p := range ulp.DefaultPatterns {
+		fmt.Fprintf(tw, "%s\tdefault\t%s\t%s\n", p.Name, p.Placeholder, p.Regex)
+	}
+	for _, p := range ulp.ExtendedPatterns {
+		fmt.Fprintf(tw, "%s\textended\t%s\t%s\n", p.Name, p.Placeholder, p.Regex)
+	}
+	tw.Flush()
+}
+
+// splitNames splits a comma-separated list of names.
+func splitNames(s string) []string {
+	names := strings.Split(s, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
+}
+
+// inputFile is an input log file, decompressed if needed.
+type inputFile struct {
+	io.Reader
+	f       *os.File
+	counter *countingReader // bytes read from f, before decompression
+}
+
+// openInput opens a log file, detecting compression like ulp.OpenLog but
+// also counting the bytes read from the file for progress reporting.
+func openInput(path string) (*inputFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{r: f}
+	r, err := ulp.Decompress(counter)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &inputFile{Reader: r, f: f, counter: counter}, nil
+}
+
+func (in *inputFile) Close() error {
+	return in.f.Close()
+}
+
+// countingReader counts the bytes read from r. The count may be read
+// concurrently.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// loadModel reads a saved model file and returns its parser and templates.
+func loadModel(path string, opts ...ulp.Option) (*ulp.Parser, []*ulp.LogTemplate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return ulp.LoadModel(f, opts...)
+}
+
+// writeModel saves the parser and its templates to a model file.
+func writeModel(path string, parser *ulp.Parser, templates []*ulp.LogTemplate) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := parser.SaveModel(f, templates); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeStreamEvents re-reads the input file and writes its events as they
+// are classified against result, returning the number of events written.
+func writeStreamEvents(w io.Writer, parser *ulp.Parser, path string, result *ulp.ParseResult, format string, progress *progressPrinter) (int, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if progress != nil {
+		progress.pos = f.counter.n.Load
+	}
+
+	ew, err := newEventWriter(w, format, result.HeaderFields, false)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for ev, err := range parser.StreamEvents(f, result) {
+		if err != nil {
+			return n, err
+		}
+		if err := ew.write(ev); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, ew.close()
 }
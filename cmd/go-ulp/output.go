@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	ulp "github.com/n0madic/go-ulp"
 )
@@ -26,15 +27,39 @@ func writeTemplates(w io.Writer, result *ulp.ParseResult, format string) error {
 
 // writeEvents outputs all events with their template assignments.
 func writeEvents(w io.Writer, result *ulp.ParseResult, format string) error {
+	ew, err := newEventWriter(w, format, result.HeaderFields, result.Sources != nil)
+	if err != nil {
+		return err
+	}
+	for _, ev := range result.Events {
+		if err := ew.write(ev); err != nil {
+			return err
+		}
+	}
+	return ew.close()
+}
+
+// eventWriter writes events one at a time so that output can be produced
+// while events are still being streamed.
+type eventWriter interface {
+	write(ev *ulp.LogEvent) error
+	flush() error // writes buffered events, for output that never ends
+	close() error
+}
+
+// newEventWriter creates an eventWriter for the given output format.
+// headerFields lists the header columns in output order; sources adds the
+// source file of each event, for input read from several files.
+func newEventWriter(w io.Writer, format string, headerFields []string, sources bool) (eventWriter, error) {
 	switch format {
 	case "csv":
-		return writeEventsCSV(w, result)
+		return newCSVEventWriter(w, headerFields, sources)
 	case "json":
-		return writeEventsJSON(w, result)
+		return &jsonEventWriter{w: w}, nil
 	case "text":
-		return writeEventsText(w, result)
+		return &textEventWriter{w: w, sources: sources}, nil
 	default:
-		return fmt.Errorf("unknown format: %s", format)
+		return nil, fmt.Errorf("unknown format: %s", format)
 	}
 }
 
@@ -44,47 +69,90 @@ func writeTemplatesCSV(w io.Writer, result *ulp.ParseResult) error {
 	cw := csv.NewWriter(w)
 	defer cw.Flush()
 
-	if err := cw.Write([]string{"Template", "Count"}); err != nil {
+	// Input from several files gets a count column per file
+	header := append([]string{"Template", "Count"}, result.Sources...)
+	if err := cw.Write(header); err != nil {
 		return err
 	}
 	for _, t := range result.Templates {
-		if err := cw.Write([]string{t.Template, strconv.Itoa(t.Count)}); err != nil {
+		record := []string{t.Template, strconv.Itoa(t.Count)}
+		for _, src := range result.Sources {
+			record = append(record, strconv.Itoa(t.SourceCounts[src]))
+		}
+		if err := cw.Write(record); err != nil {
 			return err
 		}
 	}
 	return cw.Error()
 }
 
-func writeEventsCSV(w io.Writer, result *ulp.ParseResult) error {
+type csvEventWriter struct {
+	cw           *csv.Writer
+	headerFields []string
+	sources      bool
+}
+
+func newCSVEventWriter(w io.Writer, headerFields []string, sources bool) (*csvEventWriter, error) {
 	cw := csv.NewWriter(w)
-	defer cw.Flush()
+	header := []string{"LineID"}
+	if sources {
+		header = append(header, "Source", "Line")
+	}
+	header = append(header, headerFields...)
+	header = append(header, "EventID", "Content", "ParameterList")
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvEventWriter{cw: cw, headerFields: headerFields, sources: sources}, nil
+}
 
-	if err := cw.Write([]string{"LineID", "EventID", "Content"}); err != nil {
+func (c *csvEventWriter) write(ev *ulp.LogEvent) error {
+	params, err := marshalList(parameterValues(ev))
+	if err != nil {
 		return err
 	}
-	for _, ev := range result.Events {
-		if err := cw.Write([]string{
-			strconv.Itoa(ev.LineID),
-			ev.EventID,
-			ev.RawContent,
-		}); err != nil {
-			return err
-		}
+	record := []string{strconv.Itoa(ev.LineID)}
+	if c.sources {
+		record = append(record, ev.Source, strconv.Itoa(ev.StartLine))
 	}
-	return cw.Error()
+	for _, name := range c.headerFields {
+		record = append(record, ev.Headers[name])
+	}
+	record = append(record, ev.EventID, ev.RawContent, params)
+	return c.cw.Write(record)
+}
+
+func (c *csvEventWriter) flush() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+func (c *csvEventWriter) close() error {
+	return c.flush()
 }
 
 // JSON writers
 
 type templateJSON struct {
-	Template string `json:"template"`
-	Count    int    `json:"count"`
+	Template string         `json:"template"`
+	Count    int            `json:"count"`
+	Sources  map[string]int `json:"sources,omitempty"`
 }
 
 type eventJSON struct {
-	LineID  int    `json:"line_id"`
-	EventID string `json:"event_id"`
-	Content string `json:"content"`
+	LineID     int               `json:"line_id"`
+	Source     string            `json:"source,omitempty"`
+	Line       int               `json:"line,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	EventID    string            `json:"event_id"`
+	Content    string            `json:"content"`
+	Parameters []string          `json:"parameters"`
+	// ParameterTypes holds the placeholder type of each parameter, if any
+	// parameter is typed
+	ParameterTypes []string `json:"parameter_types,omitempty"`
+	// ParameterOffsets holds the byte offsets of each parameter in the
+	// content, end exclusive, or -1 if unknown
+	ParameterOffsets [][2]int `json:"parameter_offsets,omitempty"`
 }
 
 func writeTemplatesJSON(w io.Writer, result *ulp.ParseResult) error {
@@ -93,6 +161,7 @@ func writeTemplatesJSON(w io.Writer, result *ulp.ParseResult) error {
 		items = append(items, templateJSON{
 			Template: t.Template,
 			Count:    t.Count,
+			Sources:  t.SourceCounts,
 		})
 	}
 	enc := json.NewEncoder(w)
@@ -100,18 +169,82 @@ func writeTemplatesJSON(w io.Writer, result *ulp.ParseResult) error {
 	return enc.Encode(items)
 }
 
-func writeEventsJSON(w io.Writer, result *ulp.ParseResult) error {
-	items := make([]eventJSON, 0, len(result.Events))
-	for _, ev := range result.Events {
-		items = append(items, eventJSON{
-			LineID:  ev.LineID,
-			EventID: ev.EventID,
-			Content: ev.RawContent,
-		})
+// jsonEventWriter emits a JSON array incrementally, formatted the same way
+// as an indented json.Encoder would format the whole slice.
+type jsonEventWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonEventWriter) write(ev *ulp.LogEvent) error {
+	e := eventJSON{
+		LineID:     ev.LineID,
+		Headers:    ev.Headers,
+		EventID:    ev.EventID,
+		Content:    ev.RawContent,
+		Parameters: parameterValues(ev),
 	}
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	return enc.Encode(items)
+	for i, p := range ev.Parameters {
+		if p.Type != "" && e.ParameterTypes == nil {
+			e.ParameterTypes = make([]string, len(ev.Parameters))
+		}
+		if e.ParameterTypes != nil {
+			e.ParameterTypes[i] = p.Type
+		}
+		e.ParameterOffsets = append(e.ParameterOffsets, [2]int{p.Start, p.End})
+	}
+	if ev.Source != "" {
+		e.Source = ev.Source
+		e.Line = ev.StartLine
+	}
+	data, err := json.MarshalIndent(e, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.count == 0 {
+		sep = "[\n  "
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonEventWriter) flush() error {
+	return nil
+}
+
+func (j *jsonEventWriter) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// parameterValues returns the event's parameter values in template order.
+// The result is never nil so that JSON output renders an empty list.
+func parameterValues(ev *ulp.LogEvent) []string {
+	values := make([]string, 0, len(ev.Parameters))
+	for _, p := range ev.Parameters {
+		values = append(values, p.Value)
+	}
+	return values
+}
+
+// marshalList encodes values as a compact JSON array for a single CSV cell.
+func marshalList(values []string) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(values); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
 }
 
 // Text writers
@@ -121,15 +254,36 @@ func writeTemplatesText(w io.Writer, result *ulp.ParseResult) error {
 		if _, err := fmt.Fprintf(w, "(%d events) %s\n", t.Count, t.Template); err != nil {
 			return err
 		}
+		// Per-file breakdown for input read from several files
+		for _, src := range result.Sources {
+			if n := t.SourceCounts[src]; n > 0 {
+				if _, err := fmt.Fprintf(w, "    %d\t%s\n", n, src); err != nil {
+					return err
+				}
+			}
+		}
 	}
 	return nil
 }
 
-func writeEventsText(w io.Writer, result *ulp.ParseResult) error {
-	for _, ev := range result.Events {
-		if _, err := fmt.Fprintf(w, "%d\t%s\n", ev.LineID, ev.RawContent); err != nil {
-			return err
-		}
+type textEventWriter struct {
+	w       io.Writer
+	sources bool
+}
+
+func (t *textEventWriter) write(ev *ulp.LogEvent) error {
+	if t.sources {
+		_, err := fmt.Fprintf(t.w, "%d\t%s:%d\t%s\n", ev.LineID, ev.Source, ev.StartLine, ev.RawContent)
+		return err
 	}
+	_, err := fmt.Fprintf(t.w, "%d\t%s\n", ev.LineID, ev.RawContent)
+	return err
+}
+
+func (t *textEventWriter) flush() error {
+	return nil
+}
+
+func (t *textEventWriter) close() error {
 	return nil
 }
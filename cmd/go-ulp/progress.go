@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	ulp "github.com/n0madic/go-ulp"
+)
+
+const (
+	// progressRefresh limits how often the progress line is redrawn on a
+	// terminal.
+	progressRefresh = 200 * time.Millisecond
+	// progressLogInterval limits how often a progress line is written when
+	// the output is not a terminal, as for a redirected log.
+	progressLogInterval = 5 * time.Second
+)
+
+// progressPrinter renders parsing progress, with the read percentage
+// computed from the input size. On a terminal it is a single line that is
+// redrawn in place; otherwise plain lines are written now and then, so that
+// logs and CI output get no control codes. The position is taken from pos,
+// which counts bytes read from the file itself and thus stays accurate for
+// compressed input. Without pos, as for several files opened by the parser,
+// only the lines read are shown.
+type progressPrinter struct {
+	w     io.Writer
+	tty   bool
+	size  int64
+	pos   func() int64
+	last  time.Time
+	line  string
+	drawn bool
+}
+
+func newProgressPrinter(w io.Writer, size int64, pos func() int64) *progressPrinter {
+	return &progressPrinter{w: w, tty: isTerminal(w), size: size, pos: pos}
+}
+
+// isTerminal reports whether w is a character device such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// report shows the progress, throttled except for the final report of
+// each phase.
+func (pp *progressPrinter) report(p ulp.Progress) {
+	var read int64
+	if pp.pos != nil {
+		read = pp.pos()
+	}
+	phaseDone := pp.pos != nil && read == pp.size && p.Groups == 0 ||
+		p.Groups > 0 && p.GroupsTemplated == p.Groups
+	interval := progressRefresh
+	if !pp.tty {
+		interval = progressLogInterval
+	}
+	if !phaseDone && time.Since(pp.last) < interval {
+		return
+	}
+
+	var line string
+	switch {
+	case p.Groups == 0 && pp.pos == nil:
+		line = fmt.Sprintf("Reading:    %d lines", p.LinesRead)
+	case p.Groups == 0:
+		pct := float64(read) * 100 / float64(pp.size)
+		line = fmt.Sprintf("Reading:    %5.1f%% (%d lines)", pct, p.LinesRead)
+	default:
+		line = fmt.Sprintf("Templating: %d/%d groups (%d lines)", p.GroupsTemplated, p.Groups, p.LinesRead)
+	}
+	if line == pp.line {
+		return
+	}
+	pp.last, pp.line = time.Now(), line
+	if !pp.tty {
+		fmt.Fprintln(pp.w, line)
+		return
+	}
+	pp.drawn = true
+	fmt.Fprintf(pp.w, "\r%s\033[K", line)
+}
+
+// finish ends the current progress line so that subsequent output starts
+// clean. It is a no-op on a nil printer or when nothing has been drawn.
+func (pp *progressPrinter) finish() {
+	if pp == nil || !pp.drawn {
+		return
+	}
+	fmt.Fprintln(pp.w)
+	pp.drawn = false
+}
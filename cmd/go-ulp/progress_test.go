@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	ulp "github.com/n0madic/go-ulp"
+)
+
+func TestProgressPlainLines(t *testing.T) {
+	// Off a terminal, progress is written as plain lines and the final
+	// report of each phase is written once
+	var out strings.Builder
+	var read int64
+	pp := newProgressPrinter(&out, 100, func() int64 { return read })
+	if pp.tty {
+		t.Fatal("strings.Builder detected as a terminal")
+	}
+	pp.report(ulp.Progress{LinesRead: 1})
+	read = 100
+	pp.report(ulp.Progress{LinesRead: 2})
+	pp.report(ulp.Progress{LinesRead: 2})
+	pp.report(ulp.Progress{LinesRead: 2, Groups: 1})
+	pp.report(ulp.Progress{LinesRead: 2, Groups: 1, GroupsTemplated: 1})
+	pp.finish()
+
+	want := "Reading:      0.0% (1 lines)\n" +
+		"Reading:    100.0% (2 lines)\n" +
+		"Templating: 1/1 groups (2 lines)\n"
+	if out.String() != want {
+		t.Errorf("progress output = %q, want %q", out.String(), want)
+	}
+}
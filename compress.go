@@ -0,0 +1,81 @@
+package ulp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Magic bytes of the supported compression formats.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// Decompress returns a reader that transparently decompresses r if it starts
+// with the magic bytes of gzip (including concatenated members, as written
+// by logrotate), bzip2 or zlib. Other input is returned unchanged, buffered.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// Peek errors just mean the input is too short for any magic
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, bzip2Magic) && len(magic) == 4 && magic[3] >= '1' && magic[3] <= '9':
+		return bzip2.NewReader(br), nil
+	case isZlibHeader(magic):
+		return zlib.NewReader(br)
+	}
+	return br, nil
+}
+
+// isZlibHeader reports whether b starts with a zlib header using deflate
+// with a 32K window. Only the flag bytes written by common compression
+// levels are accepted, so that text starting with "x^" isn't mistaken for
+// zlib data.
+func isZlibHeader(b []byte) bool {
+	if len(b) < 2 || b[0] != 0x78 {
+		return false
+	}
+	switch b[1] {
+	case 0x01, 0x9c, 0xda:
+		return true
+	}
+	return false
+}
+
+// logFile closes the underlying file of a possibly decompressed log.
+type logFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (l *logFile) Close() error {
+	if c, ok := l.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return l.f.Close()
+}
+
+// OpenLog opens the log file at path for reading, decompressing gzip, bzip2
+// and zlib files as detected by Decompress, so that rotated archives can be
+// passed to Parse directly.
+func OpenLog(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &logFile{Reader: r, f: f}, nil
+}
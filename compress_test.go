@@ -0,0 +1,98 @@
+package ulp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestOpenLog(t *testing.T) {
+	want, err := os.ReadFile("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+
+	for _, name := range []string{
+		"testdata/hdfs_sample.log",
+		"testdata/hdfs_sample.log.gz",
+		"testdata/hdfs_sample.log.bz2",
+		"testdata/hdfs_sample.log.zlib",
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := OpenLog(name)
+			if err != nil {
+				t.Fatalf("OpenLog() error = %v", err)
+			}
+			defer f.Close()
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("OpenLog() content differs from the uncompressed file")
+			}
+		})
+	}
+}
+
+func TestOpenLogErrors(t *testing.T) {
+	if _, err := OpenLog("testdata/missing.log"); err == nil {
+		t.Error("OpenLog() error = nil for a missing file")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "plain text", input: []byte("hello world\n"), want: "hello world\n"},
+		{name: "short input", input: []byte("x"), want: "x"},
+		{name: "empty", input: nil, want: ""},
+		{name: "text starting like zlib", input: []byte("x^2 + y^2\n"), want: "x^2 + y^2\n"},
+		{name: "text starting like bzip2", input: []byte("BZh is not a level\n"), want: "BZh is not a level\n"},
+		{name: "truncated gzip", input: []byte{0x1f, 0x8b, 0x08}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Decompress(bytes.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decompress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Decompress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCompressed(t *testing.T) {
+	f, err := OpenLog("testdata/hdfs_sample.log.gz")
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer f.Close()
+
+	p, err := New(WithPreset("HDFS"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(f)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(result.Events) != 12 || len(result.Templates) != 3 {
+		t.Errorf("got %d events and %d templates, want 12 and 3", len(result.Events), len(result.Templates))
+	}
+}
@@ -0,0 +1,184 @@
+package ulp
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Header fields extracted from container logs.
+var containerFields = []string{"Time", "Stream"}
+
+// partialInput is implemented by input decoders of formats that split long
+// messages over several lines. Such lines are decoded and joined into one
+// record per stream by a partialJoiner.
+type partialInput interface {
+	inputDecoder
+	// parseLine decodes a physical line; ok is false if it isn't in the
+	// format.
+	parseLine(line string) (l containerLine, ok bool)
+}
+
+// containerLine is a decoded line of a container log.
+type containerLine struct {
+	time, stream, msg string
+	partial           bool // the message continues on the next line of the stream
+}
+
+// decodeContainerLine decodes a single line of a partialInput.
+func decodeContainerLine(in partialInput, line string) (content string, headers map[string]string, ok bool) {
+	l, ok := in.parseLine(line)
+	if !ok {
+		return strings.TrimSpace(line), nil, false
+	}
+	return strings.TrimSpace(l.msg), containerHeaders(l.time, l.stream), true
+}
+
+// dockerInput decodes the json-file log driver format of Docker,
+// {"log":"message\n","stream":"stderr","time":"2024-01-15T10:30:22.123Z"}.
+// Messages longer than 16K are split into entries whose log doesn't end
+// with a newline.
+type dockerInput struct{}
+
+// dockerEntry is a line of a Docker json-file log.
+type dockerEntry struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+	Time   string  `json:"time"`
+}
+
+func (in *dockerInput) fieldNames() []string { return containerFields }
+
+func (in *dockerInput) decode(line string) (content string, headers map[string]string, ok bool) {
+	return decodeContainerLine(in, line)
+}
+
+// parseLine parses a json-file line, which must have a log field.
+func (in *dockerInput) parseLine(line string) (containerLine, bool) {
+	var e dockerEntry
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &e) != nil || e.Log == nil {
+		return containerLine{}, false
+	}
+	return containerLine{
+		time:    e.Time,
+		stream:  e.Stream,
+		msg:     *e.Log,
+		partial: !strings.HasSuffix(*e.Log, "\n"),
+	}, true
+}
+
+// criInput decodes the Kubernetes CRI log format written by containerd and
+// CRI-O, "2024-01-15T10:30:22.123456789Z stdout F message", where the tag P
+// marks a partial message continued on the next line.
+type criInput struct{}
+
+func (in *criInput) fieldNames() []string { return containerFields }
+
+func (in *criInput) decode(line string) (content string, headers map[string]string, ok bool) {
+	return decodeContainerLine(in, line)
+}
+
+// parseLine splits a CRI log line into its timestamp, stream, the first of
+// its tags and the message.
+func (in *criInput) parseLine(line string) (containerLine, bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 || parts[0] == "" || (parts[1] != "stdout" && parts[1] != "stderr") {
+		return containerLine{}, false
+	}
+	tag, _, _ := strings.Cut(parts[2], ":")
+	if tag != "F" && tag != "P" {
+		return containerLine{}, false
+	}
+	l := containerLine{time: parts[0], stream: parts[1], partial: tag == "P"}
+	if len(parts) == 4 {
+		l.msg = parts[3]
+	}
+	return l, true
+}
+
+// containerHeaders returns the header fields of a container log record.
+func containerHeaders(time, stream string) map[string]string {
+	headers := make(map[string]string, len(containerFields))
+	if time != "" {
+		headers["Time"] = time
+	}
+	if stream != "" {
+		headers["Stream"] = stream
+	}
+	return headers
+}
+
+// partialJoiner reassembles the records of a partialInput from lines whose
+// message continues on the next line of the same stream, so that the parts
+// of interleaved stdout and stderr messages are joined separately. Lines are
+// decoded once, and records carry their decoded content instead of their
+// text. Messages are capped at maxLineBytes; further parts of longer ones are
+// dropped.
+type partialJoiner struct {
+	in      partialInput
+	pending map[string]*partialRecord // by stream
+}
+
+// partialRecord is a message whose final part hasn't been read yet.
+type partialRecord struct {
+	msg        strings.Builder
+	time       string
+	start, end int
+}
+
+// add consumes a physical line and returns the record it completes, if any:
+// the line itself, or the message of its stream that it ends.
+func (j *partialJoiner) add(line string, lineNo int) (rawRecord, bool) {
+	l, ok := j.in.parseLine(line)
+	if !ok {
+		return rawRecord{
+			text:      line,
+			startLine: lineNo,
+			endLine:   lineNo,
+			decoded:   &decodedRecord{content: strings.TrimSpace(line)},
+		}, true
+	}
+	rec := j.pending[l.stream]
+	if rec == nil {
+		rec = &partialRecord{time: l.time, start: lineNo}
+	}
+	if rec.msg.Len()+len(l.msg) <= maxLineBytes {
+		rec.msg.WriteString(l.msg)
+	}
+	rec.end = lineNo
+	if l.partial {
+		if j.pending == nil {
+			j.pending = make(map[string]*partialRecord)
+		}
+		j.pending[l.stream] = rec
+		return rawRecord{}, false
+	}
+	delete(j.pending, l.stream)
+	return rec.record(l.stream), true
+}
+
+// flush returns the records left incomplete at the end of the input, in
+// the order they started.
+func (j *partialJoiner) flush() []rawRecord {
+	recs := make([]rawRecord, 0, len(j.pending))
+	for stream, rec := range j.pending {
+		recs = append(recs, rec.record(stream))
+	}
+	slices.SortFunc(recs, func(a, b rawRecord) int { return a.startLine - b.startLine })
+	clear(j.pending)
+	return recs
+}
+
+// record returns the joined record of a message of stream.
+func (r *partialRecord) record(stream string) rawRecord {
+	return rawRecord{
+		startLine: r.start,
+		endLine:   r.end,
+		decoded: &decodedRecord{
+			content: strings.TrimSpace(r.msg.String()),
+			headers: containerHeaders(r.time, stream),
+			ok:      true,
+		},
+	}
+}
@@ -0,0 +1,195 @@
+package ulp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          partialInput
+		record      string
+		wantContent string
+		wantHeaders map[string]string
+		wantOK      bool
+		wantPartial bool
+	}{
+		{
+			name:        "docker",
+			in:          &dockerInput{},
+			record:      `{"log":"GET /health 200\n","stream":"stdout","time":"2024-01-15T10:30:22.1Z"}`,
+			wantContent: "GET /health 200",
+			wantHeaders: map[string]string{"Stream": "stdout", "Time": "2024-01-15T10:30:22.1Z"},
+			wantOK:      true,
+		},
+		{
+			name:        "docker partial",
+			in:          &dockerInput{},
+			record:      `{"log":"first half, ","stream":"stderr","time":"2024-01-15T10:30:22.1Z"}`,
+			wantContent: "first half,",
+			wantHeaders: map[string]string{"Stream": "stderr", "Time": "2024-01-15T10:30:22.1Z"},
+			wantOK:      true,
+			wantPartial: true,
+		},
+		{
+			name:        "docker without log field",
+			in:          &dockerInput{},
+			record:      `{"msg":"hello"}`,
+			wantContent: `{"msg":"hello"}`,
+		},
+		{
+			name:        "docker not JSON",
+			in:          &dockerInput{},
+			record:      "plain text",
+			wantContent: "plain text",
+		},
+		{
+			name:        "cri",
+			in:          &criInput{},
+			record:      "2024-01-15T10:30:22.123456789Z stdout F GET /health 200",
+			wantContent: "GET /health 200",
+			wantHeaders: map[string]string{"Stream": "stdout", "Time": "2024-01-15T10:30:22.123456789Z"},
+			wantOK:      true,
+		},
+		{
+			name:        "cri partial",
+			in:          &criInput{},
+			record:      "2024-01-15T10:30:22.1Z stderr P first half, ",
+			wantContent: "first half,",
+			wantHeaders: map[string]string{"Stream": "stderr", "Time": "2024-01-15T10:30:22.1Z"},
+			wantOK:      true,
+			wantPartial: true,
+		},
+		{
+			name:        "cri empty message",
+			in:          &criInput{},
+			record:      "2024-01-15T10:30:22.1Z stdout F",
+			wantContent: "",
+			wantHeaders: map[string]string{"Stream": "stdout", "Time": "2024-01-15T10:30:22.1Z"},
+			wantOK:      true,
+		},
+		{
+			name:        "cri unknown stream",
+			in:          &criInput{},
+			record:      "2024-01-15T10:30:22.1Z stdin F hello",
+			wantContent: "2024-01-15T10:30:22.1Z stdin F hello",
+		},
+		{
+			name:        "cri unknown tag",
+			in:          &criInput{},
+			record:      "2024-01-15T10:30:22.1Z stdout X hello",
+			wantContent: "2024-01-15T10:30:22.1Z stdout X hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, headers, ok := tt.in.decode(tt.record)
+			if ok != tt.wantOK {
+				t.Fatalf("decode() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if content != tt.wantContent {
+				t.Errorf("decode() content = %q, want %q", content, tt.wantContent)
+			}
+			if !reflect.DeepEqual(headers, tt.wantHeaders) {
+				t.Errorf("decode() headers = %v, want %v", headers, tt.wantHeaders)
+			}
+			if l, _ := tt.in.parseLine(tt.record); l.partial != tt.wantPartial {
+				t.Errorf("parseLine() partial = %v, want %v", l.partial, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestParseContainerLogs(t *testing.T) {
+	for _, preset := range []string{"docker", "cri"} {
+		t.Run(preset, func(t *testing.T) {
+			result := parseFile(t, "testdata/"+preset+".log", WithPreset(preset))
+
+			if !reflect.DeepEqual(result.HeaderFields, containerFields) {
+				t.Errorf("HeaderFields = %v, want %v", result.HeaderFields, containerFields)
+			}
+			if result.Stats.Lines != 6 || result.Stats.HeaderMismatches != 1 {
+				t.Errorf("Stats = %+v, want 6 lines with 1 mismatch", result.Stats)
+			}
+
+			// The partial lines 3 and 4 form one event
+			ev := result.Events[2]
+			if ev.RawContent != "Request failed: upstream timed out after 30s" || ev.StartLine != 3 || ev.EndLine != 4 {
+				t.Errorf("event 3 = %q lines %d-%d", ev.RawContent, ev.StartLine, ev.EndLine)
+			}
+			if ev.Headers["Stream"] != "stderr" {
+				t.Errorf("event 3 headers = %v", ev.Headers)
+			}
+
+			templates := make(map[string]int)
+			for _, tmpl := range result.Templates {
+				templates[tmpl.Template] = tmpl.Count
+			}
+			for tmpl, want := range map[string]int{
+				"Connection from <*> accepted":                 2,
+				"Request failed: upstream timed out after <*>": 2,
+			} {
+				if templates[tmpl] != want {
+					t.Errorf("template %q count = %d, want %d; templates: %v", tmpl, templates[tmpl], want, templates)
+				}
+			}
+		})
+	}
+}
+
+func TestPartialJoiner(t *testing.T) {
+	// The parts of a stdout and a stderr message interleaved with a
+	// complete line and one that isn't in the format
+	lines := []string{
+		"2024-01-15T10:30:22.1Z stdout P out first, ",
+		"2024-01-15T10:30:22.2Z stderr P err first, ",
+		"2024-01-15T10:30:22.3Z stdout P out second, ",
+		"not a cri line",
+		"2024-01-15T10:30:22.4Z stderr F err last",
+		"2024-01-15T10:30:22.5Z stderr F err whole",
+		"2024-01-15T10:30:22.6Z stdout F out last",
+	}
+	type record struct {
+		content    string
+		stream     string
+		start, end int
+	}
+	want := []record{
+		{"not a cri line", "", 4, 4},
+		{"err first, err last", "stderr", 2, 5},
+		{"err whole", "stderr", 6, 6},
+		{"out first, out second, out last", "stdout", 1, 7},
+	}
+	j := &partialJoiner{in: &criInput{}}
+	var got []record
+	for i, line := range lines {
+		if rec, ok := j.add(line, i+1); ok {
+			got = append(got, record{rec.decoded.content, rec.decoded.headers["Stream"], rec.startLine, rec.endLine})
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %+v, want %+v", got, want)
+	}
+}
+
+func TestPartialJoinerFlush(t *testing.T) {
+	j := &partialJoiner{in: &dockerInput{}}
+	for i, line := range []string{
+		`{"log":"err never ","stream":"stderr","time":"t2"}`,
+		`{"log":"out never ","stream":"stdout","time":"t1"}`,
+	} {
+		if _, ok := j.add(line, i+1); ok {
+			t.Fatalf("add() returned a record for partial line %d", i+1)
+		}
+	}
+	recs := j.flush()
+	if len(recs) != 2 || recs[0].decoded.content != "err never" || recs[0].startLine != 1 ||
+		recs[1].decoded.content != "out never" || recs[1].decoded.headers["Time"] != "t1" {
+		t.Errorf("flush() = %+v", recs)
+	}
+	if recs := j.flush(); len(recs) != 0 {
+		t.Errorf("second flush() = %+v", recs)
+	}
+}
@@ -0,0 +1,149 @@
+package ulp
+
+import (
+	"slices"
+	"strings"
+)
+
+// extractParameters fills Parameters for every event by aligning its token
+// string against the final template it was assigned to.
+func extractParameters(events []*LogEvent, templates []*LogTemplate, wildcard string, typed map[string]string) {
+	tokensByID := make(map[string][]string, len(templates))
+	for _, tmpl := range templates {
+		tokensByID[tmpl.TemplateID] = strings.Fields(tmpl.Template)
+	}
+	for _, ev := range events {
+		tmplTokens, ok := tokensByID[ev.TemplateID]
+		if !ok {
+			continue
+		}
+		tokens := strings.Fields(ev.TokenString)
+		spans, _ := matchTemplate(tmplTokens, tokens, wildcard, typed)
+		ev.Parameters = locateParameters(ev, tokens, spans)
+	}
+}
+
+// locateParameters returns the parameters of ev for the spans of its tokens
+// matched by a template. Their offsets in the raw content of ev are those of
+// the first and last token of each span, and their values are taken from it,
+// so that values replaced during preprocessing are reported as they were.
+func locateParameters(ev *LogEvent, tokens []string, spans []tokenSpan) []Parameter {
+	if len(spans) == 0 {
+		return nil
+	}
+	params := make([]Parameter, len(spans))
+	for i, sp := range spans {
+		param := Parameter{
+			Position: sp.start,
+			Value:    strings.Join(tokens[sp.start:sp.end], " "),
+			Type:     sp.typ,
+			Start:    -1,
+			End:      -1,
+		}
+		if sp.end <= len(ev.TokenOffsets) {
+			first, last := ev.TokenOffsets[sp.start], ev.TokenOffsets[sp.end-1]
+			if first[0] < first[1] && last[0] < last[1] && first[0] <= last[1] && last[1] <= len(ev.RawContent) {
+				param.Start, param.End = first[0], last[1]
+				param.Value = ev.RawContent[param.Start:param.End]
+			}
+		}
+		params[i] = param
+	}
+	return params
+}
+
+// matchTemplate aligns template tokens against event tokens and returns the
+// spans of tokens covered by each wildcard and typed placeholder. A wildcard
+// matches one or more consecutive tokens; when several alignments exist the
+// shortest match is preferred, scanning left to right. A typed placeholder
+// listed in typed matches itself. Returns false if the tokens don't fit the
+// template.
+//
+// The runs of literal tokens between wildcards are placed at their leftmost
+// occurrence, which gives the shortest matches and leaves the most tokens
+// to the rest of the template, so the alignment takes linear time.
+func matchTemplate(tmplTokens, tokens []string, wildcard string, typed map[string]string) ([]tokenSpan, bool) {
+	var spans []tokenSpan
+	literal := func(run []string, pos int) {
+		for i, tok := range run {
+			if typ, ok := typed[tok]; ok {
+				spans = append(spans, tokenSpan{start: pos + i, end: pos + i + 1, typ: typ})
+			}
+		}
+	}
+
+	// The leading run is anchored at the start
+	ti := 0
+	for ti < len(tmplTokens) && tmplTokens[ti] != wildcard {
+		ti++
+	}
+	if ti > len(tokens) || !slices.Equal(tokens[:ti], tmplTokens[:ti]) {
+		return nil, false
+	}
+	literal(tmplTokens[:ti], 0)
+	ei := ti
+	if ti == len(tmplTokens) {
+		return spans, ei == len(tokens)
+	}
+
+	// Each wildcard is followed by a run, the last of which is anchored at
+	// the end
+	for ti < len(tmplTokens) {
+		if ei == len(tokens) {
+			return nil, false
+		}
+		next := ti + 1
+		for next < len(tmplTokens) && tmplTokens[next] != wildcard {
+			next++
+		}
+		run := tmplTokens[ti+1 : next]
+		var pos int
+		if next == len(tmplTokens) {
+			pos = len(tokens) - len(run)
+			if pos <= ei || !slices.Equal(tokens[pos:], run) {
+				return nil, false
+			}
+		} else {
+			i := indexTokens(tokens[ei+1:], run)
+			if i < 0 {
+				return nil, false
+			}
+			pos = ei + 1 + i
+		}
+		spans = append(spans, tokenSpan{start: ei, end: pos})
+		literal(run, pos)
+		ti, ei = next, pos+len(run)
+	}
+	return spans, true
+}
+
+// indexTokens returns the index of the first occurrence of sub in tokens, or
+// -1, in time linear in their lengths (Knuth-Morris-Pratt).
+func indexTokens(tokens, sub []string) int {
+	if len(sub) == 0 {
+		return 0
+	}
+	// fail[i] is the length of the longest proper border of sub[:i+1]
+	fail := make([]int, len(sub))
+	for i, k := 1, 0; i < len(sub); i++ {
+		for k > 0 && sub[i] != sub[k] {
+			k = fail[k-1]
+		}
+		if sub[i] == sub[k] {
+			k++
+		}
+		fail[i] = k
+	}
+	for i, k := 0, 0; i < len(tokens); i++ {
+		for k > 0 && tokens[i] != sub[k] {
+			k = fail[k-1]
+		}
+		if tokens[i] == sub[k] {
+			k++
+		}
+		if k == len(sub) {
+			return i - len(sub) + 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,300 @@
+package ulp
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		tokens   string
+		want     []tokenSpan
+		wantOK   bool
+	}{
+		{
+			name:     "single-token wildcards",
+			template: "PacketResponder <*> for block <*> terminating",
+			tokens:   "PacketResponder 0 for block blk_111 terminating",
+			want:     []tokenSpan{{start: 1, end: 2}, {start: 4, end: 5}},
+			wantOK:   true,
+		},
+		{
+			name:     "wildcard spans several tokens",
+			template: "error <*> occurred",
+			tokens:   "error disk full occurred",
+			want:     []tokenSpan{{start: 1, end: 3}},
+			wantOK:   true,
+		},
+		{
+			name:     "trailing wildcard",
+			template: "user <*>",
+			tokens:   "user alice logged in",
+			want:     []tokenSpan{{start: 1, end: 4}},
+			wantOK:   true,
+		},
+		{
+			name:     "shortest match preferred",
+			template: "a <*> b <*>",
+			tokens:   "a x b y b z",
+			want:     []tokenSpan{{start: 1, end: 2}, {start: 3, end: 6}},
+			wantOK:   true,
+		},
+		{
+			name:     "adjacent wildcards",
+			template: "copy <*> <*> done",
+			tokens:   "copy a b c done",
+			want:     []tokenSpan{{start: 1, end: 2}, {start: 2, end: 4}},
+			wantOK:   true,
+		},
+		{
+			name:     "repeated run",
+			template: "<*> a a b <*>",
+			tokens:   "x a a a b y",
+			want:     []tokenSpan{{start: 0, end: 2}, {start: 5, end: 6}},
+			wantOK:   true,
+		},
+		{
+			name:     "trailing run anchored at the end",
+			template: "<*> done",
+			tokens:   "a done b done",
+			want:     []tokenSpan{{start: 0, end: 3}},
+			wantOK:   true,
+		},
+		{
+			name:     "no wildcards",
+			template: "server started",
+			tokens:   "server started",
+			wantOK:   true,
+		},
+		{
+			name:     "literal mismatch",
+			template: "server <*> started",
+			tokens:   "client 1 started",
+			wantOK:   false,
+		},
+		{
+			name:     "wildcard needs at least one token",
+			template: "server <*> started",
+			tokens:   "server started",
+			wantOK:   false,
+		},
+		{
+			name:     "typed placeholders",
+			template: "connect from <IP> at <*> on <DATE>",
+			tokens:   "connect from <IP> at noon on <DATE>",
+			want: []tokenSpan{
+				{start: 2, end: 3, typ: "IP"},
+				{start: 4, end: 5},
+				{start: 6, end: 7, typ: "DATE"},
+			},
+			wantOK: true,
+		},
+		{
+			name:     "typed placeholder matches only itself",
+			template: "connect from <IP>",
+			tokens:   "connect from <HOST>",
+			wantOK:   false,
+		},
+		{
+			name:     "unknown placeholder is literal",
+			template: "value <NAME> set",
+			tokens:   "value <NAME> set",
+			wantOK:   true,
+		},
+	}
+	typed := map[string]string{"<IP>": "IP", "<DATE>": "DATE", "<HOST>": "HOST"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchTemplate(strings.Fields(tt.template), strings.Fields(tt.tokens), "<*>", typed)
+			if ok != tt.wantOK {
+				t.Fatalf("matchTemplate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchTemplate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTemplateLongLine(t *testing.T) {
+	// Alignment is linear, so a line of a million tokens is matched at once
+	const n = 1 << 20
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = "x"
+	}
+	tokens[0], tokens[n/2], tokens[n-1] = "start", "middle", "end"
+	spans, ok := matchTemplate(strings.Fields("start <*> middle <*> end"), tokens, "<*>", nil)
+	want := []tokenSpan{{start: 1, end: n / 2}, {start: n/2 + 1, end: n - 1}}
+	if !ok || !reflect.DeepEqual(spans, want) {
+		t.Errorf("matchTemplate() = %v, %v, want %v", spans, ok, want)
+	}
+}
+
+func TestParseExtractsParameters(t *testing.T) {
+	f, err := os.Open("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to open test data: %v", err)
+	}
+	defer f.Close()
+
+	p, err := New(
+		WithHeaderFormat("<Date> <Time> <Pid> <Level> <Component>: <Content>"),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := p.Parse(f)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	// Line 1: PacketResponder 0 for block blk_38865049064139660 terminating
+	want := []Parameter{
+		{Position: 1, Value: "0", Start: 16, End: 17},
+		{Position: 4, Value: "blk_38865049064139660", Start: 28, End: 49},
+	}
+	if got := result.Events[0].Parameters; !reflect.DeepEqual(got, want) {
+		t.Errorf("event 1 parameters = %v, want %v", got, want)
+	}
+
+	for _, ev := range result.Events {
+		if len(ev.Parameters) == 0 {
+			t.Errorf("event %d has no parameters (template %s)", ev.LineID, ev.TemplateID)
+		}
+	}
+}
+
+func TestParameterOffsets(t *testing.T) {
+	input := `Login from 10.0.0.1 by {u1} took 150ms
+Login from 192.168.1.100 by {u22} took 2s
+Login from 10.0.0.7 by {u3} took 30s
+`
+	for _, typed := range []bool{false, true} {
+		p, err := New(WithTypedPlaceholders(typed))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		result, err := p.Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		want := [][]string{
+			{"10.0.0.1", "u1", "150ms"},
+			{"192.168.1.100", "u22", "2s"},
+			{"10.0.0.7", "u3", "30s"},
+		}
+		for i, ev := range result.Events {
+			var got []string
+			for _, param := range ev.Parameters {
+				if param.Start < 0 || ev.RawContent[param.Start:param.End] != param.Value {
+					t.Errorf("typed=%v line %d: parameter %+v doesn't point into %q", typed, ev.LineID, param, ev.RawContent)
+				}
+				got = append(got, param.Value)
+			}
+			if !reflect.DeepEqual(got, want[i]) {
+				t.Errorf("typed=%v line %d: parameters = %q, want %q", typed, ev.LineID, got, want[i])
+			}
+		}
+
+		m := NewMatcher(p, result.Templates)
+		if _, params, ok := m.Match("Login from 10.1.2.3 by {u4} took 1h"); !ok || !reflect.DeepEqual(params, []string{"10.1.2.3", "u4", "1h"}) {
+			t.Errorf("typed=%v: Match() = %q, %v", typed, params, ok)
+		}
+	}
+}
+
+func TestLocateParameters(t *testing.T) {
+	// "a {b c} d" with a token added by a preprocessor
+	ev := &LogEvent{
+		RawContent:   "a {b c} d",
+		TokenString:  "a b c d x",
+		TokenOffsets: [][2]int{{0, 1}, {3, 4}, {5, 6}, {8, 9}, {0, 0}},
+	}
+	got := locateParameters(ev, strings.Fields(ev.TokenString), []tokenSpan{
+		{start: 1, end: 3},
+		{start: 4, end: 5},
+		{start: 3, end: 5},
+	})
+	want := []Parameter{
+		{Position: 1, Value: "b c", Start: 3, End: 6},
+		{Position: 4, Value: "x", Start: -1, End: -1},
+		{Position: 3, Value: "d x", Start: -1, End: -1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("locateParameters() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParameterOffsetsUnknown(t *testing.T) {
+	// Tokens added by a preprocessor have no offsets; their parameters keep
+	// the token as value
+	extra := func(next Preprocessor) Preprocessor {
+		return PreprocessorFunc(func(content string) []Token {
+			return append([]Token{{Text: intToStr(len(content))}}, next.Preprocess(content)...)
+		})
+	}
+	p, err := New(WithPreprocessor(extra))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader("open 1\nopen 22\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []Parameter{
+		{Position: 0, Value: "6", Start: -1, End: -1},
+		{Position: 2, Value: "1", Start: 5, End: 6},
+	}
+	if got := result.Events[0].Parameters; !reflect.DeepEqual(got, want) {
+		t.Errorf("parameters = %+v, want %+v", got, want)
+	}
+}
+
+func TestWithParametersDisabled(t *testing.T) {
+	p, err := New(WithParameters(false))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader("open 1\nopen 22\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(result.Templates) != 1 || result.Templates[0].Template != "open <*>" {
+		t.Fatalf("templates = %+v, want open <*>", result.Templates)
+	}
+	for _, ev := range result.Events {
+		if ev.Parameters != nil {
+			t.Errorf("event %d parameters = %+v, want none", ev.LineID, ev.Parameters)
+		}
+	}
+	if ev := p.Add("open 3"); ev.Parameters != nil {
+		t.Errorf("Add() parameters = %+v, want none", ev.Parameters)
+	}
+}
+
+func TestParameterOffsetsSeveralTokens(t *testing.T) {
+	// Offsets come from the first and last token of the span, whatever
+	// separates them in the content
+	p, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader("error 1   22\t333 occurred\nerror 4 55 666 occurred\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(result.Templates) != 1 || result.Templates[0].Template != "error <*> occurred" {
+		t.Fatalf("templates = %v, want [error <*> occurred]", templateStrings(result.Templates))
+	}
+	want := []Parameter{{Position: 1, Value: "1   22\t333", Start: 6, End: 16}}
+	if got := result.Events[0].Parameters; !reflect.DeepEqual(got, want) {
+		t.Errorf("parameters = %+v, want %+v", got, want)
+	}
+}
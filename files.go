@@ -0,0 +1,348 @@
+package ulp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseFiles parses the log files matching paths as one input, learning a
+// single set of templates. Each path is a file, a directory, which is read
+// recursively, or a glob pattern where "**" matches any number of
+// directories. Files are opened with OpenLog and read concurrently, but
+// events are numbered in the order of the expanded paths, so LineIDs don't
+// depend on scheduling. Each event records its file in Source, and each
+// template the number of events per file in SourceCounts.
+func (p *Parser) ParseFiles(paths ...string) (*ParseResult, error) {
+	return p.ParseFilesContext(context.Background(), paths...)
+}
+
+// ParseFilesContext is like ParseFiles but stops reading and template
+// generation as soon as ctx is done, returning ctx.Err().
+func (p *Parser) ParseFilesContext(ctx context.Context, paths ...string) (*ParseResult, error) {
+	start := time.Now()
+	files, err := ExpandPaths(paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := p.scanOptions(nil)
+	perFile, err := p.readFiles(ctx, files, scan)
+	if err != nil {
+		return nil, err
+	}
+
+	// Number the events across files in path order
+	var events []*LogEvent
+	var stats ParseStats
+	fields := make(fieldSet)
+	for i, f := range perFile {
+		for _, ev := range f.events {
+			ev.LineID = len(events) + 1
+			ev.Source = files[i]
+			events = append(events, ev)
+		}
+		stats.Lines += f.stats.Lines
+		stats.HeaderMismatches += f.stats.HeaderMismatches
+		stats.Skipped += f.stats.Skipped
+		for name := range f.fields {
+			fields[name] = struct{}{}
+		}
+	}
+
+	result, err := p.learn(ctx, events, scan.progress)
+	if err != nil {
+		return nil, err
+	}
+	countSources(result.Templates, events)
+	result.HeaderFields = p.headerFields(fields)
+	result.Sources = files
+	result.Stats = stats
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// fileEvents holds the events read from one file by readFiles.
+type fileEvents struct {
+	events []*LogEvent
+	stats  ParseStats
+	fields fieldSet
+}
+
+// readFiles reads and preprocesses files concurrently, up to maxWorkers at
+// a time, sharing the preprocessing workers between them. Rejects are
+// buffered per file and written in path order, each file's as soon as it
+// and the files before it have been read. The first error cancels the
+// remaining files.
+func (p *Parser) readFiles(ctx context.Context, files []string, scan scanOptions) ([]fileEvents, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrent := min(len(files), max(1, p.maxWorkers))
+	scan.workers = max(1, p.maxWorkers/concurrent)
+	sem := make(chan struct{}, concurrent)
+
+	rejects := newOrderedRejects(scan.rejects, len(files))
+	results := make([]fileEvents, len(files))
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for i, path := range files {
+		wg.Go(func() {
+			defer func() {
+				if err := rejects.done(i); err != nil {
+					cancel()
+				}
+			}()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			opts := scan
+			opts.progress = scan.progress.source()
+			opts.stats = &results[i].stats
+			opts.rejects = rejects.file(i)
+			if scan.fields != nil {
+				opts.fields = make(fieldSet)
+				results[i].fields = opts.fields
+			}
+			results[i].events, errs[i] = p.readFile(ctx, path, opts)
+			if errs[i] != nil {
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+
+	// Report a file's own error rather than the cancellation it caused
+	for _, err := range errs {
+		if err != nil && err != context.Canceled {
+			return nil, err
+		}
+	}
+	if rejects.err != nil {
+		return nil, rejects.err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// readFile reads and preprocesses the log file at path.
+func (p *Parser) readFile(ctx context.Context, path string, opts scanOptions) ([]*LogEvent, error) {
+	f, err := OpenLog(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events, err := p.readAndPreprocess(ctx, f, opts)
+	if err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return events, err
+}
+
+// orderedRejects collects the rejects of files read concurrently and writes
+// them to w in file order.
+type orderedRejects struct {
+	w       io.Writer
+	mu      sync.Mutex
+	buffers []*bytes.Buffer
+	read    []bool
+	next    int   // first file whose rejects weren't written yet
+	err     error // first write error
+}
+
+func newOrderedRejects(w io.Writer, files int) *orderedRejects {
+	r := &orderedRejects{w: w}
+	if w != nil {
+		r.buffers = make([]*bytes.Buffer, files)
+		for i := range r.buffers {
+			r.buffers[i] = new(bytes.Buffer)
+		}
+		r.read = make([]bool, files)
+	}
+	return r
+}
+
+// file returns the writer for the rejects of file i, nil if there is no
+// rejects writer.
+func (r *orderedRejects) file(i int) io.Writer {
+	if r.w == nil {
+		return nil
+	}
+	return r.buffers[i]
+}
+
+// done marks file i as read and writes the rejects of the files read so far
+// in order, returning the first write error.
+func (r *orderedRejects) done(i int) error {
+	if r.w == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.read[i] = true
+	for r.next < len(r.read) && r.read[r.next] {
+		if r.err == nil {
+			_, r.err = r.buffers[r.next].WriteTo(r.w)
+		}
+		r.buffers[r.next] = nil
+		r.next++
+	}
+	return r.err
+}
+
+// countSources sets the per-file event counts of templates.
+func countSources(templates []*LogTemplate, events []*LogEvent) {
+	byID := make(map[string]*LogTemplate, len(templates))
+	for _, tmpl := range templates {
+		tmpl.SourceCounts = make(map[string]int)
+		byID[tmpl.TemplateID] = tmpl
+	}
+	for _, ev := range events {
+		if tmpl := byID[ev.TemplateID]; tmpl != nil {
+			tmpl.SourceCounts[ev.Source]++
+		}
+	}
+}
+
+// ExpandPaths expands file paths, directories and glob patterns into a
+// sorted, duplicate-free list of files per argument, in argument order.
+// Directories are walked recursively. Glob patterns use filepath.Match
+// syntax per path element, where an element "**" matches zero or more
+// directories. A pattern that matches no files is an error.
+func ExpandPaths(patterns ...string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := expandPath(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files, nil
+}
+
+// expandPath returns the files for a single ExpandPaths argument.
+func expandPath(pattern string) ([]string, error) {
+	// Existing paths are taken literally, even if they contain metacharacters
+	if info, err := os.Stat(pattern); err == nil || !hasMeta(pattern) {
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return []string{pattern}, nil
+		}
+		return walkFiles(pattern, nil)
+	}
+
+	// Walk from the longest directory prefix without metacharacters
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	n := 0
+	for n < len(elems)-1 && !hasMeta(elems[n]) {
+		n++
+	}
+	root := filepath.FromSlash(strings.Join(elems[:n], "/"))
+	switch {
+	case n == 0:
+		root = "."
+	case root == "":
+		root = "/" // absolute pattern with a metacharacter right after the root
+	}
+	for _, elem := range elems[n:] {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return walkFiles(root, elems[n:])
+}
+
+// walkFiles returns the regular files below root, in lexical order, whose
+// path relative to root matches the pattern elements, or all of them if
+// elems is nil.
+func walkFiles(root string, elems []string) ([]string, error) {
+	if _, err := os.Stat(root); elems != nil && os.IsNotExist(err) {
+		return nil, nil // no matches, reported by ExpandPaths
+	}
+	deep := slices.Contains(elems, "**")
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if elems == nil {
+			if d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(filepath.ToSlash(rel), "/") + 1
+		if d.IsDir() {
+			// Without "**" nothing deeper than the pattern can match
+			if rel != "." && !deep && depth >= len(elems) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !matchElems(elems, strings.Split(filepath.ToSlash(rel), "/")) {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	return files, err
+}
+
+// matchElems reports whether the path elements match the pattern
+// elements, with "**" matching zero or more path elements.
+func matchElems(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchElems(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	// Patterns were validated by expandPath
+	ok, _ := filepath.Match(pattern[0], path[0])
+	return ok && matchElems(pattern[1:], path[1:])
+}
+
+// hasMeta reports whether s contains glob metacharacters.
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
@@ -0,0 +1,250 @@
+package ulp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestFiles creates files with the given contents below a temporary
+// directory and returns the directory.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.log":           "x\n",
+		"b.log":           "x\n",
+		"notes.txt":       "x\n",
+		"app/c.log":       "x\n",
+		"app/old/d.log":   "x\n",
+		"app/old/e.log.1": "x\n",
+	})
+	rel := func(paths ...string) []string {
+		for i, p := range paths {
+			paths[i] = filepath.Join(dir, filepath.FromSlash(p))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  string
+	}{
+		{"file", rel("b.log"), rel("b.log"), ""},
+		{"files in argument order", rel("b.log", "a.log"), rel("b.log", "a.log"), ""},
+		{"duplicates", rel("a.log", "*.log"), rel("a.log", "b.log"), ""},
+		{"glob", rel("*.log"), rel("a.log", "b.log"), ""},
+		{"glob in directory", rel("app/*/*.log"), rel("app/old/d.log"), ""},
+		{"double star", rel("**/*.log"), rel("a.log", "app/c.log", "app/old/d.log", "b.log"), ""},
+		{"double star in the middle", rel("app/**/d.log"), rel("app/old/d.log"), ""},
+		{"directory", rel("app"), rel("app/c.log", "app/old/d.log", "app/old/e.log.1"), ""},
+		{"no match", rel("*.gz"), nil, "no files match"},
+		{"missing file", rel("missing.log"), nil, "no such file"},
+		{"bad pattern", rel("[a.log"), nil, "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandPaths(tt.patterns...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ExpandPaths() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandPaths() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"1.log": "INFO Connected to 10.0.0.1\nINFO Connected to 10.0.0.2\nWARN Disk 1 full\n",
+		"2.log": "INFO Connected to 10.0.0.3\nWARN Disk 2 full\nWARN Disk 3 full\n",
+		"3.log": "",
+	})
+
+	p, err := New(WithHeaderFormat("<Level> <Content>"), WithMaxWorkers(3))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.ParseFiles(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatalf("ParseFiles() error = %v", err)
+	}
+
+	wantSources := []string{filepath.Join(dir, "1.log"), filepath.Join(dir, "2.log"), filepath.Join(dir, "3.log")}
+	if !reflect.DeepEqual(result.Sources, wantSources) {
+		t.Errorf("Sources = %v, want %v", result.Sources, wantSources)
+	}
+	if result.Stats.Lines != 6 {
+		t.Errorf("Stats.Lines = %d, want 6", result.Stats.Lines)
+	}
+
+	// LineIDs follow the path order, line numbers are per file
+	wantEvents := []struct {
+		source string
+		line   int
+	}{
+		{"1.log", 1}, {"1.log", 2}, {"1.log", 3},
+		{"2.log", 1}, {"2.log", 2}, {"2.log", 3},
+	}
+	if len(result.Events) != len(wantEvents) {
+		t.Fatalf("got %d events, want %d", len(result.Events), len(wantEvents))
+	}
+	for i, want := range wantEvents {
+		ev := result.Events[i]
+		if ev.LineID != i+1 || ev.Source != filepath.Join(dir, want.source) || ev.StartLine != want.line {
+			t.Errorf("event %d: LineID=%d Source=%s StartLine=%d, want %d %s %d",
+				i, ev.LineID, ev.Source, ev.StartLine, i+1, want.source, want.line)
+		}
+	}
+
+	counts := make(map[string]map[string]int)
+	for _, tmpl := range result.Templates {
+		counts[tmpl.Template] = tmpl.SourceCounts
+	}
+	want := map[string]map[string]int{
+		"Connected to <*>": {wantSources[0]: 2, wantSources[1]: 1},
+		"Disk <*> full":    {wantSources[0]: 1, wantSources[1]: 2},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("SourceCounts = %v, want %v", counts, want)
+	}
+}
+
+func TestParseFilesRejectsOrder(t *testing.T) {
+	// Files of different sizes, so that they are read in another order
+	files := make(map[string]string)
+	var want strings.Builder
+	for i := range 8 {
+		name := fmt.Sprintf("%d.log", i)
+		var b strings.Builder
+		for j := range (8 - i) * 200 {
+			fmt.Fprintf(&b, "INFO request %d done\n", j)
+			if j%50 == 0 {
+				line := fmt.Sprintf("garbage from %s line %d", name, j)
+				b.WriteString(line + "\n")
+				want.WriteString(line + "\n")
+			}
+		}
+		files[name] = b.String()
+	}
+	dir := writeTestFiles(t, files)
+
+	for range 3 {
+		var rejects strings.Builder
+		p, err := New(
+			WithHeaderRegex(`^(?P<Level>[A-Z]+) (?P<Content>.*)$`),
+			WithRejectWriter(&rejects),
+			WithMaxWorkers(4),
+		)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if _, err := p.ParseFiles(filepath.Join(dir, "*.log")); err != nil {
+			t.Fatalf("ParseFiles() error = %v", err)
+		}
+		if rejects.String() != want.String() {
+			t.Fatalf("rejects are not in path order:\n%s", rejects.String())
+		}
+	}
+}
+
+func TestParseFilesDeterministic(t *testing.T) {
+	files := make(map[string]string)
+	for i := range 8 {
+		var b strings.Builder
+		for j := range 300 {
+			b.WriteString("INFO Task ")
+			b.WriteString(strings.Repeat("x", i+1))
+			b.WriteString(" step ")
+			b.WriteString(strings.Repeat("y", j%7+1))
+			b.WriteString("\n")
+		}
+		files[string(rune('a'+i))+".log"] = b.String()
+	}
+	dir := writeTestFiles(t, files)
+
+	p, err := New(WithHeaderFormat("<Level> <Content>"), WithMaxWorkers(4))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	first, err := p.ParseFiles(dir)
+	if err != nil {
+		t.Fatalf("ParseFiles() error = %v", err)
+	}
+	for range 3 {
+		result, err := p.ParseFiles(dir)
+		if err != nil {
+			t.Fatalf("ParseFiles() error = %v", err)
+		}
+		for i, ev := range result.Events {
+			want := first.Events[i]
+			if ev.LineID != want.LineID || ev.Source != want.Source || ev.RawContent != want.RawContent {
+				t.Fatalf("event %d differs between runs: %+v, want %+v", i, ev, want)
+			}
+		}
+	}
+}
+
+func TestParseFilesErrors(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := p.ParseFiles("testdata/*.missing"); err == nil {
+		t.Error("ParseFiles() error = nil for a pattern without matches")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.ParseFilesContext(ctx, "testdata/sample.log", "testdata/hdfs_sample.log"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseFilesContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestParseFilesCompressed(t *testing.T) {
+	p, err := New(WithPreset("HDFS"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.ParseFiles("testdata/hdfs_sample.log", "testdata/hdfs_sample.log.gz")
+	if err != nil {
+		t.Fatalf("ParseFiles() error = %v", err)
+	}
+	single := parseFile(t, "testdata/hdfs_sample.log", WithPreset("HDFS"))
+	if len(result.Events) != 2*len(single.Events) {
+		t.Errorf("got %d events, want %d", len(result.Events), 2*len(single.Events))
+	}
+	for _, tmpl := range result.Templates {
+		a, b := tmpl.SourceCounts["testdata/hdfs_sample.log"], tmpl.SourceCounts["testdata/hdfs_sample.log.gz"]
+		if a != b || a+b != tmpl.Count {
+			t.Errorf("template %q: SourceCounts = %v, Count = %d", tmpl.Template, tmpl.SourceCounts, tmpl.Count)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package ulp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"time"
+)
+
+// followPoll is how often Follow checks a file for new data, rotation and
+// truncation once it has reached the end.
+var followPoll = 250 * time.Millisecond
+
+// Follow returns the lines of the file at path starting at byte offset,
+// and keeps returning lines as they are appended, like tail -f. When the
+// path is rotated, i.e. refers to a different file than the one being read,
+// the new file is read from its start once the old one is exhausted; when
+// the file shrinks below the read position, as with copytruncate, it is
+// read again from its start. A trailing line without newline is held back
+// until it is completed or the file is rotated. Lines are returned without
+// their line ending. Lines longer than maxLineBytes, the limit of Parse, are
+// skipped with an error wrapping bufio.ErrTooLong, after which the sequence
+// goes on if the caller keeps iterating. Otherwise it ends when ctx is done
+// or on the first error.
+func Follow(ctx context.Context, path string, offset int64) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		f, err := os.Open(path)
+		if err != nil {
+			yield("", err)
+			return
+		}
+		defer func() { f.Close() }()
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			yield("", err)
+			return
+		}
+
+		pos := offset
+		var partial []byte
+		skipping := false // the rest of an overlong line is discarded
+		tooLong := fmt.Errorf("%s: skipped a line longer than %d bytes: %w", path, maxLineBytes, bufio.ErrTooLong)
+		// emit yields a complete line and reports whether to go on
+		emit := func(line []byte) bool {
+			switch {
+			case skipping:
+				skipping = false
+				return true
+			case len(line) > maxLineBytes:
+				return yield("", tooLong)
+			}
+			return yield(string(bytes.TrimSuffix(line, []byte("\r"))), nil)
+		}
+		buf := make([]byte, 64*1024)
+		ticker := time.NewTicker(followPoll)
+		defer ticker.Stop()
+		for ctx.Err() == nil {
+			n, err := f.Read(buf)
+			if n > 0 {
+				pos += int64(n)
+				data := append(partial, buf[:n]...)
+				for {
+					i := bytes.IndexByte(data, '\n')
+					if i < 0 {
+						break
+					}
+					if !emit(data[:i]) {
+						return
+					}
+					data = data[i+1:]
+				}
+				if !skipping && len(data) > maxLineBytes {
+					if !yield("", tooLong) {
+						return
+					}
+					skipping = true
+				}
+				if skipping {
+					data = data[:0]
+				}
+				partial = append(partial[:0], data...)
+				continue
+			}
+			if err != nil && err != io.EOF {
+				yield("", err)
+				return
+			}
+
+			// At the end of the file: wait, then check for rotation
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			rotated, truncated, err := followCheck(f, path, pos)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			switch {
+			case rotated:
+				// Read what was written to the old file since the last read
+				if rest, err := io.ReadAll(f); err == nil && len(rest) > 0 {
+					partial = append(partial, rest...)
+				}
+				for _, line := range bytes.Split(partial, []byte("\n")) {
+					if (len(line) > 0 || skipping) && !emit(line) {
+						return
+					}
+				}
+				partial, skipping = partial[:0], false
+				nf, err := os.Open(path)
+				if err != nil {
+					yield("", err)
+					return
+				}
+				f.Close()
+				f, pos = nf, 0
+			case truncated:
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					yield("", err)
+					return
+				}
+				partial, pos, skipping = partial[:0], 0, false
+			}
+		}
+	}
+}
+
+// followCheck reports whether path now refers to another file than f, or
+// f was truncated below pos. A missing path, as between the rename and the
+// creation of the new file during rotation, is neither.
+func followCheck(f *os.File, path string, pos int64) (rotated, truncated bool, err error) {
+	cur, err := f.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if !os.SameFile(cur, info) {
+		return true, false, nil
+	}
+	return false, cur.Size() < pos, nil
+}
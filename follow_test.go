@@ -0,0 +1,130 @@
+package ulp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollow(t *testing.T) {
+	defer func(d time.Duration) { followPoll = d }(followPoll)
+	followPoll = 5 * time.Millisecond
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	write := func(name, data string, flag int) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join(dir, name), flag|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	write("app.log", "old line\nfirst\n", 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		for line, err := range Follow(ctx, path, int64(len("old line\n"))) {
+			if err != nil {
+				done <- err
+				return
+			}
+			lines <- line
+		}
+		done <- nil
+	}()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Fatalf("Follow() line = %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	expect("first")
+
+	// Appended lines, with a partial line held back until completed
+	write("app.log", "second\r\nthi", os.O_APPEND)
+	expect("second")
+	write("app.log", "rd\n", os.O_APPEND)
+	expect("third")
+
+	// Rotation: the rest of the old file comes first, then the new file
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	write("app.log.1", "last of old", os.O_APPEND)
+	write("app.log", "rotated\n", 0)
+	expect("last of old")
+	expect("rotated")
+
+	// Truncation in place, as by copytruncate
+	write("app.log", "new\n", os.O_TRUNC)
+	expect("new")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Follow() error = %v", err)
+	}
+}
+
+func TestFollowMissingFile(t *testing.T) {
+	for _, err := range Follow(context.Background(), "testdata/missing.log", 0) {
+		if err == nil {
+			t.Fatal("Follow() error = nil for a missing file")
+		}
+		return
+	}
+	t.Fatal("Follow() returned no error for a missing file")
+}
+
+func TestFollowLongLine(t *testing.T) {
+	defer func(d time.Duration) { followPoll = d }(followPoll)
+	followPoll = 5 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	long := strings.Repeat("x", maxLineBytes+1)
+	// Lines just over the limit, found whole in a read, and far over it,
+	// dropped while still incomplete
+	if err := os.WriteFile(path, []byte("before\n"+long+"\nbetween\n"+long+long+"\nafter\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []string
+	for line, err := range Follow(ctx, path, 0) {
+		if err != nil {
+			if !errors.Is(err, bufio.ErrTooLong) {
+				t.Fatalf("Follow() error = %v, want bufio.ErrTooLong", err)
+			}
+			line = "<too long>"
+		}
+		got = append(got, line)
+		if line == "after" {
+			break
+		}
+	}
+	want := []string{"before", "<too long>", "between", "<too long>", "after"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Follow() lines = %q, want %q", got, want)
+	}
+}
@@ -74,10 +74,20 @@ func groupEvents(events []*LogEvent) map[string]*LogGroup {
 			groups[ev.EventID] = g
 		}
 		g.Events = append(g.Events, ev)
+		g.Count++
 	}
 	return groups
 }
 
+// size returns the number of events in the group, falling back to the
+// retained events when Count isn't set.
+func (g *LogGroup) size() int {
+	if g.Count > 0 {
+		return g.Count
+	}
+	return len(g.Events)
+}
+
 // intToStr converts a small non-negative int to string without fmt.Sprintf.
 func intToStr(n int) string {
 	if n == 0 {
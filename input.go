@@ -0,0 +1,228 @@
+package ulp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// inputDecoder extracts the content and header fields from records of a
+// structured input format, replacing the header format. ok is false if the
+// record isn't in the expected format, in which case the whole line is
+// returned as content.
+type inputDecoder interface {
+	decode(line string) (content string, headers map[string]string, ok bool)
+	// fieldNames returns the header fields in output order, or nil if
+	// they depend on the input
+	fieldNames() []string
+}
+
+// jsonInput decodes JSON lines, taking the content from the string at path
+// and the headers from the remaining top-level fields.
+type jsonInput struct {
+	path []string
+}
+
+// newJSONInput returns a decoder for the dotted message path, e.g. "msg" or
+// "log.message".
+func newJSONInput(messagePath string) (*jsonInput, error) {
+	if messagePath == "" {
+		return nil, fmt.Errorf("JSON message path cannot be empty")
+	}
+	path := strings.Split(messagePath, ".")
+	for _, key := range path {
+		if key == "" {
+			return nil, fmt.Errorf("invalid JSON message path %q", messagePath)
+		}
+	}
+	return &jsonInput{path: path}, nil
+}
+
+func (in *jsonInput) fieldNames() []string { return nil }
+
+func (in *jsonInput) decode(line string) (content string, headers map[string]string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return line, nil, false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return line, nil, false
+	}
+
+	headers = make(map[string]string, len(fields))
+	for key, raw := range fields {
+		if len(in.path) == 1 && key == in.path[0] {
+			continue
+		}
+		headers[key] = jsonString(raw)
+	}
+
+	raw, found := fields[in.path[0]]
+	for _, key := range in.path[1:] {
+		if !found {
+			break
+		}
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			found = false
+			break
+		}
+		raw, found = obj[key]
+	}
+	if !found {
+		return line, headers, false
+	}
+	return strings.TrimSpace(jsonString(raw)), headers, true
+}
+
+// jsonString returns a JSON string value unquoted, null as an empty string
+// and any other value as compact JSON text.
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var b bytes.Buffer
+	if json.Compact(&b, raw) != nil {
+		return string(raw)
+	}
+	return b.String()
+}
+
+// logfmtInput decodes logfmt lines such as
+// `level=info msg="connection reset" peer=10.0.0.1`, taking the content from
+// the value of key and the headers from the other pairs.
+type logfmtInput struct {
+	key string
+}
+
+func (in *logfmtInput) fieldNames() []string { return nil }
+
+func (in *logfmtInput) decode(line string) (content string, headers map[string]string, ok bool) {
+	line = strings.TrimSpace(line)
+	pairs, valid := parseLogfmt(line)
+	if !valid {
+		return line, nil, false
+	}
+	headers = make(map[string]string, len(pairs))
+	for k, v := range pairs {
+		if k != in.key {
+			headers[k] = v
+		}
+	}
+	msg, found := pairs[in.key]
+	if !found {
+		return line, headers, false
+	}
+	return strings.TrimSpace(msg), headers, true
+}
+
+// parseLogfmt splits a logfmt line into key/value pairs. Values may be
+// double-quoted with Go escapes; a key without "=" has an empty value.
+// A line is valid if it has at least one key=value pair and no malformed
+// keys or quotes, so plain text isn't mistaken for bare keys.
+func parseLogfmt(line string) (map[string]string, bool) {
+	pairs := make(map[string]string)
+	hasValue := false
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, false
+		}
+		if i == len(line) || line[i] != '=' {
+			if i < len(line) && line[i] == '"' {
+				return nil, false
+			}
+			pairs[key] = ""
+			continue
+		}
+		i++ // skip '='
+		hasValue = true
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			v, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			pairs[key] = v
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		pairs[key] = line[start:i]
+	}
+	return pairs, hasValue
+}
+
+// foldFields appends the header fields of structured input to the
+// preprocessed content as "key=<wildcard>" tokens, sorted by key, so that
+// templates reflect which fields a message carries. The tokens have no
+// offsets, since they are not part of the content.
+func (p *Parser) foldFields(tokens []Token, headers map[string]string) []Token {
+	if len(headers) == 0 {
+		return tokens
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		tokens = append(tokens, Token{Text: k + "=" + p.dynamicWildcard})
+	}
+	return tokens
+}
+
+// fieldSet collects the header field names seen in structured input, where
+// the set of fields isn't known in advance.
+type fieldSet map[string]struct{}
+
+func (s fieldSet) add(headers map[string]string) {
+	for name := range headers {
+		s[name] = struct{}{}
+	}
+}
+
+// headerFields returns the header field names for a result: the names from
+// the header format or input decoder, or the sorted names in seen for
+// structured input without fixed fields.
+func (p *Parser) headerFields(seen fieldSet) []string {
+	if p.input == nil {
+		return p.headerNames()
+	}
+	if names := p.input.fieldNames(); names != nil {
+		return names
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
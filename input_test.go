@@ -0,0 +1,261 @@
+package ulp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONInputDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		line        string
+		wantContent string
+		wantHeaders map[string]string
+		wantOK      bool
+	}{
+		{
+			name:        "top-level message",
+			path:        "msg",
+			line:        `{"level":"info","ts":1700000000.5,"msg":"connection reset","peer":"10.0.0.1"}`,
+			wantContent: "connection reset",
+			wantHeaders: map[string]string{"level": "info", "ts": "1700000000.5", "peer": "10.0.0.1"},
+			wantOK:      true,
+		},
+		{
+			name:        "nested message",
+			path:        "log.message",
+			line:        `{"log":{"message":"disk full","file":"a.go"},"ok":false}`,
+			wantContent: "disk full",
+			wantHeaders: map[string]string{"log": `{"message":"disk full","file":"a.go"}`, "ok": "false"},
+			wantOK:      true,
+		},
+		{
+			name:        "non-string values kept as JSON",
+			path:        "msg",
+			line:        `{"msg":"done","tags":["a", "b"],"extra":null}`,
+			wantContent: "done",
+			wantHeaders: map[string]string{"tags": `["a","b"]`, "extra": ""},
+			wantOK:      true,
+		},
+		{
+			name:        "missing message",
+			path:        "msg",
+			line:        `{"message":"other key"}`,
+			wantContent: `{"message":"other key"}`,
+			wantHeaders: map[string]string{"message": "other key"},
+		},
+		{
+			name:        "not JSON",
+			path:        "msg",
+			line:        "  panic: runtime error ",
+			wantContent: "panic: runtime error",
+		},
+		{
+			name:        "invalid JSON",
+			path:        "msg",
+			line:        `{"msg":"truncated`,
+			wantContent: `{"msg":"truncated`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := newJSONInput(tt.path)
+			if err != nil {
+				t.Fatalf("newJSONInput() error = %v", err)
+			}
+			content, headers, ok := in.decode(tt.line)
+			if content != tt.wantContent {
+				t.Errorf("decode() content = %q, want %q", content, tt.wantContent)
+			}
+			if !reflect.DeepEqual(headers, tt.wantHeaders) {
+				t.Errorf("decode() headers = %v, want %v", headers, tt.wantHeaders)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("decode() ok = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWithJSONInputErrors(t *testing.T) {
+	for _, path := range []string{"", ".msg", "log..msg"} {
+		if _, err := New(WithJSONInput(path)); err == nil {
+			t.Errorf("WithJSONInput(%q) error = nil", path)
+		}
+	}
+}
+
+func TestParseJSONInput(t *testing.T) {
+	input := `{"level":"info","msg":"user 42 logged in","service":"auth"}
+{"level":"info","msg":"user 7 logged in","service":"auth","trace":"abc"}
+plain text line
+{"level":"warn","msg":"user 42 logged in","service":"auth"}
+`
+	p, err := New(WithJSONInput("msg"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(result.Events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(result.Events))
+	}
+	if got, want := result.HeaderFields, []string{"level", "service", "trace"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HeaderFields = %v, want %v", got, want)
+	}
+	if got := result.Events[2].RawContent; got != "plain text line" {
+		t.Errorf("non-JSON line content = %q", got)
+	}
+	if result.Stats.HeaderMismatches != 1 {
+		t.Errorf("Stats.HeaderMismatches = %d, want 1", result.Stats.HeaderMismatches)
+	}
+	if ev := result.Events[3]; ev.Headers["level"] != "warn" || ev.TemplateID != result.Events[0].TemplateID {
+		t.Errorf("event 4 = %+v, want level warn in the template of event 1", ev)
+	}
+
+	var tmpl string
+	for _, tt := range result.Templates {
+		if tt.TemplateID == result.Events[0].TemplateID {
+			tmpl = tt.Template
+		}
+	}
+	if tmpl != "user <*> logged in" {
+		t.Errorf("template = %q, want %q", tmpl, "user <*> logged in")
+	}
+}
+
+func TestModelRoundTripInput(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want inputDecoder
+	}{
+		{"json", []Option{WithJSONInput("log.message")}, &jsonInput{path: []string{"log", "message"}}},
+		{"logfmt", []Option{WithLogfmtInput("message"), WithFoldKeys(true)}, &logfmtInput{key: "message"}},
+		{"docker", []Option{WithPreset("docker")}, &dockerInput{}},
+		{"cri", []Option{WithPreset("cri")}, &criInput{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := p.SaveModel(&buf, nil); err != nil {
+				t.Fatalf("SaveModel() error = %v", err)
+			}
+			loaded, _, err := LoadModel(&buf)
+			if err != nil {
+				t.Fatalf("LoadModel() error = %v", err)
+			}
+			if !reflect.DeepEqual(loaded.input, tt.want) {
+				t.Errorf("input not restored: %+v, want %+v", loaded.input, tt.want)
+			}
+			if loaded.foldKeys != p.foldKeys {
+				t.Errorf("foldKeys = %v, want %v", loaded.foldKeys, p.foldKeys)
+			}
+		})
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		line  string
+		want  map[string]string
+		valid bool
+	}{
+		{
+			line:  `level=info ts=2024-01-15T10:30:22Z msg="connection reset" peer=10.0.0.1`,
+			want:  map[string]string{"level": "info", "ts": "2024-01-15T10:30:22Z", "msg": "connection reset", "peer": "10.0.0.1"},
+			valid: true,
+		},
+		{
+			line:  `msg="say \"hi\"" empty= debug`,
+			want:  map[string]string{"msg": `say "hi"`, "empty": "", "debug": ""},
+			valid: true,
+		},
+		{line: "plain text without pairs"},
+		{line: `msg="unterminated`},
+		{line: `=value`},
+		{line: `key"quoted"=1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, valid := parseLogfmt(tt.line)
+			if valid != tt.valid {
+				t.Fatalf("parseLogfmt() valid = %v, want %v", valid, tt.valid)
+			}
+			if valid && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLogfmt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogfmtInput(t *testing.T) {
+	input := `level=info msg="connection reset" peer=10.0.0.1
+level=warn msg="connection reset" peer=10.0.0.2 retry=3
+connection reset by peer
+level=info method=GET status=200
+`
+	tests := []struct {
+		name          string
+		opts          []Option
+		wantTemplates []string
+	}{
+		{
+			name:          "message only",
+			opts:          []Option{WithLogfmtInput("msg")},
+			wantTemplates: []string{"connection reset", "connection reset by peer", "level = info method = GET status = 200"},
+		},
+		{
+			name: "fold keys",
+			opts: []Option{WithLogfmtInput("msg"), WithFoldKeys(true)},
+			wantTemplates: []string{
+				"connection reset level=<*> peer=<*>",
+				"connection reset level=<*> peer=<*> retry=<*>",
+				"connection reset by peer",
+				"level = info method = GET status = 200",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			result, err := p.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			var got []string
+			for _, tmpl := range result.Templates {
+				got = append(got, tmpl.Template)
+			}
+			if !reflect.DeepEqual(got, tt.wantTemplates) {
+				t.Errorf("templates = %q, want %q", got, tt.wantTemplates)
+			}
+			if got, want := result.HeaderFields, []string{"level", "method", "peer", "retry", "status"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("HeaderFields = %v, want %v", got, want)
+			}
+			if ev := result.Events[1]; ev.RawContent != "connection reset" || ev.Headers["retry"] != "3" {
+				t.Errorf("event 2 = %+v", ev)
+			}
+			if result.Stats.HeaderMismatches != 2 {
+				t.Errorf("Stats.HeaderMismatches = %d, want 2", result.Stats.HeaderMismatches)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package ulp
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Matcher classifies log lines against a fixed set of previously learned
+// templates without re-running the grouping algorithm. Lines go through the
+// same header extraction and preprocessing as in Parse, then are looked up
+// in a token trie built from the template tokens.
+//
+// A Matcher is safe for concurrent use.
+type Matcher struct {
+	parser    *Parser
+	root      *trieNode
+	matched   atomic.Int64
+	unmatched atomic.Int64
+}
+
+// MatchStats holds the number of lines seen by a Matcher.
+type MatchStats struct {
+	Matched   int64
+	Unmatched int64 // lines that fit no known template
+}
+
+// trieNode is a node of the template token trie. Literal tokens are looked
+// up in children; a wildcard edge matches one or more arbitrary tokens.
+type trieNode struct {
+	children map[string]*trieNode
+	wildcard *trieNode
+	template *LogTemplate // non-nil if a template ends at this node
+}
+
+// tokenSpan is the half-open token range [start, end) covered by a wildcard
+// or, with its type, by a typed placeholder.
+type tokenSpan struct {
+	start, end int
+	typ        string
+}
+
+// trieState identifies a (node, token index) pair during matching.
+type trieState struct {
+	node *trieNode
+	pos  int
+}
+
+// NewMatcher builds a Matcher for templates using the parser's header format,
+// regex patterns and wildcard. Templates are typically taken from
+// ParseResult.Templates.
+func NewMatcher(p *Parser, templates []*LogTemplate) *Matcher {
+	m := &Matcher{
+		parser: p,
+		root:   &trieNode{},
+	}
+	for _, tmpl := range templates {
+		m.insert(tmpl)
+	}
+	return m
+}
+
+// insert adds a template's token path to the trie. When two templates share
+// the same path the first one wins.
+func (m *Matcher) insert(tmpl *LogTemplate) {
+	node := m.root
+	for _, tok := range strings.Fields(tmpl.Template) {
+		if tok == m.parser.dynamicWildcard {
+			if node.wildcard == nil {
+				node.wildcard = &trieNode{}
+			}
+			node = node.wildcard
+			continue
+		}
+		child, ok := node.children[tok]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+			child = &trieNode{}
+			node.children[tok] = child
+		}
+		node = child
+	}
+	if node.template == nil {
+		node.template = tmpl
+	}
+}
+
+// Match classifies a raw log line. It returns the matching template and the
+// values of its wildcards, or false if the line fits no known template, which
+// usually means a never-before-seen message.
+func (m *Matcher) Match(line string) (*LogTemplate, []string, bool) {
+	ev := m.parser.newEvent(rawRecord{text: line})
+	tokens := strings.Fields(ev.TokenString)
+	tmpl, spans, ok := m.matchTokens(tokens)
+	if !ok {
+		return nil, nil, false
+	}
+	params := locateParameters(ev, tokens, spans)
+	values := make([]string, len(params))
+	for i, p := range params {
+		values[i] = p.Value
+	}
+	return tmpl, values, true
+}
+
+// MatchAll reads log lines from r and classifies each of them. The result has
+// the same shape as a ParseResult: events carry their TemplateID and
+// Parameters, with an empty TemplateID for unmatched lines, and Templates
+// holds copies of the known templates that occurred in r, counted over r.
+func (m *Matcher) MatchAll(r io.Reader) (*ParseResult, error) {
+	start := time.Now()
+
+	var stats ParseStats
+	scan := m.parser.scanOptions(&stats)
+	events, err := m.parser.readAndPreprocess(context.Background(), r, scan)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[*LogTemplate]int)
+	var order []*LogTemplate
+	for _, ev := range events {
+		ev.EventID = generateEventID(ev.TokenString)
+		tokens := strings.Fields(ev.TokenString)
+		tmpl, spans, ok := m.matchTokens(tokens)
+		if !ok {
+			continue
+		}
+		if counts[tmpl] == 0 {
+			order = append(order, tmpl)
+		}
+		counts[tmpl]++
+		ev.TemplateID = tmpl.TemplateID
+		if !m.parser.skipParameters {
+			ev.Parameters = locateParameters(ev, tokens, spans)
+		}
+	}
+
+	templates := make([]*LogTemplate, 0, len(order))
+	for _, tmpl := range order {
+		templates = append(templates, &LogTemplate{
+			TemplateID: tmpl.TemplateID,
+			Template:   tmpl.Template,
+			EventIDs:   tmpl.EventIDs,
+			Count:      counts[tmpl],
+		})
+	}
+
+	return &ParseResult{
+		Events:       events,
+		Templates:    templates,
+		HeaderFields: m.parser.headerFields(scan.fields),
+		Stats:        stats,
+		Duration:     time.Since(start),
+	}, nil
+}
+
+// Stats returns the number of matched and unmatched lines so far.
+func (m *Matcher) Stats() MatchStats {
+	return MatchStats{
+		Matched:   m.matched.Load(),
+		Unmatched: m.unmatched.Load(),
+	}
+}
+
+// matchTokens looks up preprocessed tokens in the trie and updates the stats.
+// It returns the matching template and the spans of its wildcards and typed
+// placeholders.
+func (m *Matcher) matchTokens(tokens []string) (*LogTemplate, []tokenSpan, bool) {
+	var spans []tokenSpan
+	failed := make(map[trieState]struct{})
+	tmpl := m.root.match(tokens, 0, &spans, failed, m.parser.placeholders)
+	if tmpl == nil {
+		m.unmatched.Add(1)
+		return nil, nil, false
+	}
+	m.matched.Add(1)
+	return tmpl, spans, true
+}
+
+// match walks the trie from n starting at tokens[pos]. Literal edges are
+// preferred over wildcards so the most specific template wins; a wildcard
+// consumes as few tokens as possible. Typed placeholders listed in typed
+// are matched literally but recorded as spans. States known to fail are
+// recorded in failed to keep backtracking linear in practice.
+func (n *trieNode) match(tokens []string, pos int, spans *[]tokenSpan, failed map[trieState]struct{}, typed map[string]string) *LogTemplate {
+	if pos == len(tokens) {
+		return n.template
+	}
+	state := trieState{node: n, pos: pos}
+	if _, ok := failed[state]; ok {
+		return nil
+	}
+
+	if child, ok := n.children[tokens[pos]]; ok {
+		mark := len(*spans)
+		if typ, ok := typed[tokens[pos]]; ok {
+			*spans = append(*spans, tokenSpan{start: pos, end: pos + 1, typ: typ})
+		}
+		if tmpl := child.match(tokens, pos+1, spans, failed, typed); tmpl != nil {
+			return tmpl
+		}
+		*spans = (*spans)[:mark]
+	}
+
+	if n.wildcard != nil {
+		mark := len(*spans)
+		for end := pos + 1; end <= len(tokens); end++ {
+			*spans = append((*spans)[:mark], tokenSpan{start: pos, end: end})
+			if tmpl := n.wildcard.match(tokens, end, spans, failed, typed); tmpl != nil {
+				return tmpl
+			}
+		}
+		*spans = (*spans)[:mark]
+	}
+
+	failed[state] = struct{}{}
+	return nil
+}
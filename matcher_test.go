@@ -0,0 +1,161 @@
+package ulp
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newHDFSMatcher(tb testing.TB) *Matcher {
+	tb.Helper()
+	f, err := os.Open("testdata/hdfs_sample.log")
+	if err != nil {
+		tb.Fatalf("failed to open test data: %v", err)
+	}
+	defer f.Close()
+
+	p, err := New(
+		WithHeaderFormat("<Date> <Time> <Pid> <Level> <Component>: <Content>"),
+	)
+	if err != nil {
+		tb.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(f)
+	if err != nil {
+		tb.Fatalf("Parse() error = %v", err)
+	}
+	return NewMatcher(p, result.Templates)
+}
+
+func TestMatcherMatch(t *testing.T) {
+	m := newHDFSMatcher(t)
+
+	tests := []struct {
+		name         string
+		line         string
+		wantTemplate string
+		wantParams   []string
+		wantOK       bool
+	}{
+		{
+			name:         "known template with new values",
+			line:         "081110 101010 999 INFO dfs.DataNode$PacketResponder: PacketResponder 7 for block blk_123456 terminating",
+			wantTemplate: "PacketResponder <*> for block <*> terminating",
+			wantParams:   []string{"7", "blk_123456"},
+			wantOK:       true,
+		},
+		{
+			name:   "never-before-seen message",
+			line:   "081110 101010 999 WARN dfs.DataNode: Disk quota exceeded on volume data1",
+			wantOK: false,
+		},
+		{
+			name:   "known prefix with extra tokens",
+			line:   "081110 101010 999 INFO dfs.DataNode$PacketResponder: PacketResponder 7 for block blk_1 terminating now",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, params, ok := m.Match(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("Match() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if tmpl.Template != tt.wantTemplate {
+				t.Errorf("Match() template = %q, want %q", tmpl.Template, tt.wantTemplate)
+			}
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("Match() params = %v, want %v", params, tt.wantParams)
+			}
+		})
+	}
+
+	stats := m.Stats()
+	if stats.Matched != 1 || stats.Unmatched != 2 {
+		t.Errorf("Stats() = %+v, want 1 matched, 2 unmatched", stats)
+	}
+}
+
+func TestMatcherPrefersLiteral(t *testing.T) {
+	p, _ := New()
+	m := NewMatcher(p, []*LogTemplate{
+		{TemplateID: "1", Template: "user <*> logged in"},
+		{TemplateID: "2", Template: "user admin logged in"},
+	})
+
+	tmpl, params, ok := m.Match("user admin logged in")
+	if !ok || tmpl.TemplateID != "2" {
+		t.Fatalf("Match() = %v, %v; want template 2", tmpl, ok)
+	}
+	if len(params) != 0 {
+		t.Errorf("Match() params = %v, want none", params)
+	}
+
+	tmpl, params, ok = m.Match("user bob logged in")
+	if !ok || tmpl.TemplateID != "1" {
+		t.Fatalf("Match() = %v, %v; want template 1", tmpl, ok)
+	}
+	if !reflect.DeepEqual(params, []string{"bob"}) {
+		t.Errorf("Match() params = %v, want [bob]", params)
+	}
+}
+
+func TestMatcherMatchesTrainingData(t *testing.T) {
+	m := newHDFSMatcher(t)
+
+	data, err := os.ReadFile("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if _, _, ok := m.Match(line); !ok {
+			t.Errorf("training line not matched: %s", line)
+		}
+	}
+}
+
+func TestMatcherMatchAll(t *testing.T) {
+	m := newHDFSMatcher(t)
+
+	input := `081110 101010 999 INFO dfs.DataNode$PacketResponder: PacketResponder 7 for block blk_1 terminating
+081110 101010 999 WARN dfs.DataNode: Disk quota exceeded on volume data1
+081110 101011 999 INFO dfs.DataNode$PacketResponder: PacketResponder 8 for block blk_2 terminating
+`
+	result, err := m.MatchAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("MatchAll() error = %v", err)
+	}
+
+	if len(result.Events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(result.Events))
+	}
+	if len(result.Templates) != 1 || result.Templates[0].Count != 2 {
+		t.Errorf("expected 1 template with count 2, got %+v", result.Templates)
+	}
+	if result.Events[1].TemplateID != "" {
+		t.Errorf("unmatched event has TemplateID %q", result.Events[1].TemplateID)
+	}
+	if got := result.Events[2].Parameters; len(got) != 2 || got[1].Value != "blk_2" {
+		t.Errorf("event 3 parameters = %v", got)
+	}
+	if m.Stats().Unmatched != 1 {
+		t.Errorf("Stats().Unmatched = %d, want 1", m.Stats().Unmatched)
+	}
+}
+
+func BenchmarkMatcherMatch(b *testing.B) {
+	m := newHDFSMatcher(b)
+	line := "081110 101010 999 INFO dfs.FSNamesystem: BLOCK* NameSystem.addStoredBlock: blockMap updated: 10.251.43.21:50010 is added to blk_-4980916519894289629 size 67108864"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, ok := m.Match(line); !ok {
+			b.Fatal("line not matched")
+		}
+	}
+}
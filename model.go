@@ -0,0 +1,237 @@
+package ulp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ModelVersion is the schema version written by SaveModel. LoadModel accepts
+// models with this or any earlier version.
+const ModelVersion = 1
+
+// modelFile is the on-disk JSON representation of a trained parser.
+// Field names are part of the stable format; new fields must be optional
+// and incompatible changes require bumping ModelVersion.
+type modelFile struct {
+	Version   int             `json:"version"`
+	Options   modelOptions    `json:"options"`
+	Templates []modelTemplate `json:"templates"`
+}
+
+type modelOptions struct {
+	HeaderFormat      string         `json:"header_format,omitempty"`
+	HeaderRegex       string         `json:"header_regex,omitempty"`
+	HeaderMismatch    string         `json:"header_mismatch,omitempty"`
+	InputFormat       string         `json:"input_format,omitempty"`
+	MessageField      string         `json:"message_field,omitempty"`
+	FoldKeys          bool           `json:"fold_keys,omitempty"`
+	ContentField      string         `json:"content_field"`
+	CustomRegex       []string       `json:"custom_regex,omitempty"`
+	Patterns          []modelPattern `json:"patterns,omitempty"`
+	TypedPlaceholders bool           `json:"typed_placeholders,omitempty"`
+	ExtendedPatterns  []string       `json:"extended_patterns,omitempty"`
+	DisabledPatterns  []string       `json:"disabled_patterns,omitempty"`
+	Punctuation       *string        `json:"punctuation,omitempty"` // nil for the default
+	Brackets          *string        `json:"brackets,omitempty"`    // nil for the default
+	SampleSize        int            `json:"sample_size"`
+	DynamicWildcard   string         `json:"dynamic_wildcard"`
+	ReplaceNumbers    bool           `json:"replace_numbers"`
+
+	Multiline         bool   `json:"multiline,omitempty"`
+	MultilineStart    string `json:"multiline_start,omitempty"`
+	MultilineMaxLines int    `json:"multiline_max_lines,omitempty"`
+	MultilineMaxBytes int    `json:"multiline_max_bytes,omitempty"`
+}
+
+type modelPattern struct {
+	Name        string `json:"name"`
+	Regex       string `json:"regex"`
+	Placeholder string `json:"placeholder,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+}
+
+type modelTemplate struct {
+	TemplateID string   `json:"template_id"`
+	Template   string   `json:"template"`
+	EventIDs   []string `json:"event_ids"`
+	Count      int      `json:"count"`
+}
+
+// SaveModel writes the parser configuration together with the learned
+// templates to w as versioned JSON. The worker count is not saved since it
+// depends on the machine the model is applied on.
+func (p *Parser) SaveModel(w io.Writer, templates []*LogTemplate) error {
+	mf := modelFile{
+		Version: ModelVersion,
+		Options: modelOptions{
+			ContentField:    p.contentField,
+			SampleSize:      p.sampleSize,
+			DynamicWildcard: p.dynamicWildcard,
+			ReplaceNumbers:  p.replaceNumbers,
+		},
+		Templates: make([]modelTemplate, 0, len(templates)),
+	}
+	switch {
+	case p.headerFormat == nil:
+	case p.headerFormat.regex != nil:
+		mf.Options.ContentField = p.headerFormat.ContentField
+		mf.Options.HeaderRegex = p.headerFormat.Format
+	default:
+		mf.Options.ContentField = p.headerFormat.ContentField
+		mf.Options.HeaderFormat = p.headerFormat.Format
+	}
+	switch in := p.input.(type) {
+	case *jsonInput:
+		mf.Options.InputFormat = "json"
+		mf.Options.MessageField = strings.Join(in.path, ".")
+	case *logfmtInput:
+		mf.Options.InputFormat = "logfmt"
+		mf.Options.MessageField = in.key
+	case *syslogInput:
+		mf.Options.InputFormat = "syslog-rfc3164"
+		if in.rfc5424 {
+			mf.Options.InputFormat = "syslog-rfc5424"
+		}
+	case *dockerInput:
+		mf.Options.InputFormat = "docker"
+	case *criInput:
+		mf.Options.InputFormat = "cri"
+	}
+	mf.Options.FoldKeys = p.foldKeys
+	if p.headerMismatch != HeaderMismatchKeep {
+		mf.Options.HeaderMismatch = p.headerMismatch.String()
+	}
+	if p.multiline {
+		mf.Options.Multiline = true
+		if p.multilineStart != nil {
+			mf.Options.MultilineStart = p.multilineStart.String()
+		}
+		mf.Options.MultilineMaxLines = p.multilineMaxLines
+		mf.Options.MultilineMaxBytes = p.multilineMaxBytes
+	}
+	for _, re := range p.customRegex {
+		mf.Options.CustomRegex = append(mf.Options.CustomRegex, re.String())
+	}
+	for _, pat := range p.patterns {
+		mf.Options.Patterns = append(mf.Options.Patterns, modelPattern{
+			Name:        pat.Name,
+			Regex:       pat.Regex.String(),
+			Placeholder: pat.Placeholder,
+			Priority:    pat.Priority,
+		})
+	}
+	mf.Options.TypedPlaceholders = p.typedPlaceholders
+	mf.Options.ExtendedPatterns = p.extendedPatterns
+	mf.Options.DisabledPatterns = p.disabledPatterns
+	if p.punctuation != defaultPunctuation {
+		mf.Options.Punctuation = &p.punctuation
+	}
+	if p.brackets != defaultBrackets {
+		mf.Options.Brackets = &p.brackets
+	}
+	for _, t := range templates {
+		mf.Templates = append(mf.Templates, modelTemplate{
+			TemplateID: t.TemplateID,
+			Template:   t.Template,
+			EventIDs:   t.EventIDs,
+			Count:      t.Count,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(mf)
+}
+
+// LoadModel reads a model written by SaveModel and returns a Parser with the
+// saved configuration and the learned templates. Additional options, such as
+// WithMaxWorkers, are applied after the saved ones.
+func LoadModel(r io.Reader, opts ...Option) (*Parser, []*LogTemplate, error) {
+	var mf modelFile
+	if err := json.NewDecoder(r).Decode(&mf); err != nil {
+		return nil, nil, fmt.Errorf("decode model: %w", err)
+	}
+	if mf.Version < 1 || mf.Version > ModelVersion {
+		return nil, nil, fmt.Errorf("unsupported model version %d (supported up to %d)", mf.Version, ModelVersion)
+	}
+
+	saved := []Option{
+		WithContentField(mf.Options.ContentField),
+		WithSampleSize(mf.Options.SampleSize),
+		WithDynamicWildcard(mf.Options.DynamicWildcard),
+		WithReplaceNumbers(mf.Options.ReplaceNumbers),
+	}
+	if mf.Options.HeaderFormat != "" {
+		saved = append(saved, WithHeaderFormat(mf.Options.HeaderFormat))
+	}
+	if mf.Options.HeaderRegex != "" {
+		saved = append(saved, WithHeaderRegex(mf.Options.HeaderRegex))
+	}
+	switch mf.Options.InputFormat {
+	case "":
+	case "json":
+		saved = append(saved, WithJSONInput(mf.Options.MessageField))
+	case "logfmt":
+		saved = append(saved, WithLogfmtInput(mf.Options.MessageField))
+	case "syslog-rfc3164", "syslog-rfc5424", "docker", "cri":
+		saved = append(saved, WithPreset(mf.Options.InputFormat))
+	default:
+		return nil, nil, fmt.Errorf("model options: unknown input format %q", mf.Options.InputFormat)
+	}
+	if mf.Options.FoldKeys {
+		saved = append(saved, WithFoldKeys(true))
+	}
+	if mf.Options.HeaderMismatch != "" {
+		policy, err := ParseHeaderMismatchPolicy(mf.Options.HeaderMismatch)
+		if err != nil {
+			return nil, nil, fmt.Errorf("model options: %w", err)
+		}
+		saved = append(saved, WithHeaderMismatch(policy))
+	}
+	if len(mf.Options.CustomRegex) > 0 {
+		saved = append(saved, WithCustomRegex(mf.Options.CustomRegex))
+	}
+	for _, pat := range mf.Options.Patterns {
+		saved = append(saved, withPattern(pat.Name, pat.Regex, pat.Placeholder, pat.Priority))
+	}
+	if len(mf.Options.ExtendedPatterns) > 0 {
+		saved = append(saved, WithExtendedPatterns(mf.Options.ExtendedPatterns...))
+	}
+	if len(mf.Options.DisabledPatterns) > 0 {
+		saved = append(saved, WithDisabledPatterns(mf.Options.DisabledPatterns...))
+	}
+	if mf.Options.Punctuation != nil {
+		saved = append(saved, WithPunctuation(*mf.Options.Punctuation))
+	}
+	if mf.Options.Brackets != nil {
+		saved = append(saved, WithBrackets(*mf.Options.Brackets))
+	}
+	if mf.Options.TypedPlaceholders {
+		saved = append(saved, WithTypedPlaceholders(true))
+	}
+	if mf.Options.Multiline {
+		saved = append(saved,
+			WithMultiline(mf.Options.MultilineStart),
+			WithMultilineLimits(mf.Options.MultilineMaxLines, mf.Options.MultilineMaxBytes),
+		)
+	}
+
+	p, err := New(append(saved, opts...)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("model options: %w", err)
+	}
+
+	templates := make([]*LogTemplate, 0, len(mf.Templates))
+	for _, t := range mf.Templates {
+		templates = append(templates, &LogTemplate{
+			TemplateID: t.TemplateID,
+			Template:   t.Template,
+			EventIDs:   t.EventIDs,
+			Count:      t.Count,
+		})
+	}
+	return p, templates, nil
+}
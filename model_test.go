@@ -0,0 +1,113 @@
+package ulp
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModelRoundTrip(t *testing.T) {
+	f, err := os.Open("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to open test data: %v", err)
+	}
+	defer f.Close()
+
+	p, err := New(
+		WithHeaderFormat("<Date> <Time> <Pid> <Level> <Component>: <Content>"),
+		WithCustomRegex([]string{`blk_-?\d+`}),
+		WithSampleSize(5),
+		WithReplaceNumbers(true),
+		WithMultiline(`^\d{6} `),
+		WithMultilineLimits(10, 4096),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(f)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.SaveModel(&buf, result.Templates); err != nil {
+		t.Fatalf("SaveModel() error = %v", err)
+	}
+
+	loaded, templates, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(templates, result.Templates) {
+		t.Errorf("loaded templates differ:\n got %+v\nwant %+v", templates, result.Templates)
+	}
+	if loaded.headerFormat == nil || loaded.headerFormat.Format != p.headerFormat.Format {
+		t.Errorf("header format not restored: %+v", loaded.headerFormat)
+	}
+	if loaded.sampleSize != 5 || !loaded.replaceNumbers || loaded.dynamicWildcard != "<*>" {
+		t.Errorf("options not restored: sampleSize=%d replaceNumbers=%v wildcard=%q",
+			loaded.sampleSize, loaded.replaceNumbers, loaded.dynamicWildcard)
+	}
+	if !loaded.multiline || loaded.multilineStart.String() != `^\d{6} ` ||
+		loaded.multilineMaxLines != 10 || loaded.multilineMaxBytes != 4096 {
+		t.Errorf("multiline options not restored: start=%v lines=%d bytes=%d",
+			loaded.multilineStart, loaded.multilineMaxLines, loaded.multilineMaxBytes)
+	}
+	if len(loaded.customRegex) != 1 || loaded.customRegex[0].String() != `blk_-?\d+` {
+		t.Errorf("custom regex not restored: %v", loaded.customRegex)
+	}
+
+	// The loaded parser must preprocess lines exactly like the original
+	line := "081109 203615 148 INFO dfs.DataNode$PacketResponder: PacketResponder 0 for block blk_1 terminating"
+	if got, want := loaded.preprocess(loaded.extractContent(line)), p.preprocess(p.extractContent(line)); got != want {
+		t.Errorf("loaded parser preprocess = %q, want %q", got, want)
+	}
+}
+
+func TestModelRoundTripHeaderRegex(t *testing.T) {
+	pattern := `^(?P<Level>[A-Z]+): (?P<Content>.*)$`
+	p, err := New(WithHeaderRegex(pattern))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.SaveModel(&buf, nil); err != nil {
+		t.Fatalf("SaveModel() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `"header_regex"`) {
+		t.Errorf("model has no header_regex option:\n%s", buf.String())
+	}
+
+	loaded, _, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+	if loaded.headerFormat == nil || loaded.headerFormat.regex == nil || loaded.headerFormat.Format != pattern {
+		t.Errorf("header regex not restored: %+v", loaded.headerFormat)
+	}
+}
+
+func TestLoadModelErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "invalid JSON", input: "{"},
+		{name: "missing version", input: `{"options":{"content_field":"Content","dynamic_wildcard":"<*>"}}`},
+		{name: "future version", input: `{"version":999,"options":{"content_field":"Content","dynamic_wildcard":"<*>"}}`},
+		{name: "invalid regex", input: `{"version":1,"options":{"content_field":"Content","dynamic_wildcard":"<*>","custom_regex":["("]}}`},
+		{name: "empty wildcard", input: `{"version":1,"options":{"content_field":"Content","dynamic_wildcard":""}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := LoadModel(strings.NewReader(tt.input)); err == nil {
+				t.Error("LoadModel() expected error, got nil")
+			}
+		})
+	}
+}
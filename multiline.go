@@ -0,0 +1,133 @@
+package ulp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Default caps for multi-line events, see WithMultilineLimits.
+const (
+	defaultMultilineMaxLines = 500
+	defaultMultilineMaxBytes = 64 * 1024
+)
+
+// rawRecord is one log event as read from the input: a single line, or a
+// first line followed by its continuation lines in multi-line mode.
+type rawRecord struct {
+	text      string         // empty for container logs, which are decoded when read
+	startLine int            // physical line number of the first line
+	endLine   int            // physical line number of the last line, including dropped ones
+	decoded   *decodedRecord // set if the record was already decoded when read
+}
+
+// decodedRecord is the result of parseHeader for a record.
+type decodedRecord struct {
+	content string
+	headers map[string]string
+	ok      bool
+}
+
+// multilineJoiner aggregates physical lines into records. A line that
+// starts a new event closes the current record; any other line is appended
+// to it until the line or byte cap is reached, after which further
+// continuation lines are dropped. Only the first line of a record is
+// decoded; the continuation lines are appended to its content.
+type multilineJoiner struct {
+	p        *Parser
+	cur      strings.Builder // text of the record, for rejects
+	content  strings.Builder
+	head     decodedRecord // the first line, decoded
+	lines    int
+	start    int
+	end      int
+	hasEvent bool
+}
+
+// eventStart decodes line if it begins a new event: it matches the start
+// pattern, or the header regex or structured input format when no pattern
+// is set, see validateMultiline.
+func (p *Parser) eventStart(line string) (decodedRecord, bool) {
+	if p.multilineStart != nil && !p.multilineStart.MatchString(line) {
+		return decodedRecord{}, false
+	}
+	content, headers, ok := p.parseHeader(line)
+	if p.multilineStart == nil && !ok {
+		return decodedRecord{}, false
+	}
+	return decodedRecord{content: content, headers: headers, ok: ok}, true
+}
+
+// validateMultiline checks that the start of multi-line events can be told
+// apart. Without a start pattern they are recognized by the header, which
+// needs a header regex or a structured input format: a separator-based
+// header format also fits continuation lines with enough words, such as
+// "Caused by: java.io.IOException: quota of 10 exceeded".
+func (p *Parser) validateMultiline() error {
+	if !p.multiline || p.multilineStart != nil || p.input != nil {
+		return nil
+	}
+	if p.headerFormat == nil || p.headerFormat.regex == nil {
+		return fmt.Errorf("multiline mode needs a start pattern, or a header regex to recognize the first line of an event")
+	}
+	return nil
+}
+
+// add consumes a physical line. If the line starts a new event, the
+// previous record is returned as complete.
+func (j *multilineJoiner) add(line string, lineNo int) (rawRecord, bool) {
+	head, start := j.p.eventStart(line)
+	if !j.hasEvent && !start {
+		// Input starting with continuation lines
+		head.content, head.headers, head.ok = j.p.parseHeader(line)
+		start = true
+	}
+	if start {
+		rec, ok := j.flush()
+		j.cur.WriteString(line)
+		j.content.WriteString(head.content)
+		j.head = head
+		j.lines = 1
+		j.start = lineNo
+		j.end = lineNo
+		j.hasEvent = true
+		return rec, ok
+	}
+
+	j.end = lineNo
+	if j.lines < j.p.multilineMaxLines && j.cur.Len()+1+len(line) <= j.p.multilineMaxBytes {
+		j.cur.WriteByte('\n')
+		j.cur.WriteString(line)
+		j.content.WriteByte('\n')
+		j.content.WriteString(line)
+		j.lines++
+	}
+	return rawRecord{}, false
+}
+
+// flush returns the pending record, if any, and resets the joiner.
+func (j *multilineJoiner) flush() (rawRecord, bool) {
+	if !j.hasEvent {
+		return rawRecord{}, false
+	}
+	head := j.head
+	head.content = strings.TrimSpace(j.content.String())
+	rec := rawRecord{
+		text:      j.cur.String(),
+		startLine: j.start,
+		endLine:   j.end,
+		decoded:   &head,
+	}
+	j.cur.Reset()
+	j.content.Reset()
+	j.hasEvent = false
+	return rec, true
+}
+
+// compileMultilineStart compiles the start pattern of WithMultiline.
+func compileMultilineStart(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
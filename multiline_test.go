@@ -0,0 +1,224 @@
+package ulp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func parseFile(t *testing.T, name string, opts ...Option) *ParseResult {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open test data: %v", err)
+	}
+	defer f.Close()
+
+	p, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(f)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return result
+}
+
+func TestMultilineStartPattern(t *testing.T) {
+	result := parseFile(t, "testdata/java_stacktrace.log",
+		WithHeaderFormat("<Date> <Time> <Level> <Content>"),
+		WithMultiline(`^\d{4}-\d{2}-\d{2} `),
+	)
+
+	if len(result.Events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(result.Events))
+	}
+
+	wantLines := [][2]int{{1, 1}, {2, 7}, {8, 8}, {9, 14}, {16, 16}}
+	for i, ev := range result.Events {
+		if ev.LineID != i+1 {
+			t.Errorf("event %d LineID = %d", i, ev.LineID)
+		}
+		if ev.StartLine != wantLines[i][0] || ev.EndLine != wantLines[i][1] {
+			t.Errorf("event %d lines = %d-%d, want %d-%d", ev.LineID, ev.StartLine, ev.EndLine, wantLines[i][0], wantLines[i][1])
+		}
+	}
+
+	trace := result.Events[1]
+	if !strings.HasPrefix(trace.RawContent, "Failed to process order 1001\njava.lang.IllegalStateException") {
+		t.Errorf("stack trace content = %q", trace.RawContent)
+	}
+	if strings.Contains(trace.TokenString, "\n") {
+		t.Errorf("token string contains line breaks: %q", trace.TokenString)
+	}
+	if trace.Headers["Level"] != "ERROR" {
+		t.Errorf("stack trace Level = %q, want ERROR", trace.Headers["Level"])
+	}
+
+	// Both stack traces share a template; no "at <*>" templates
+	for _, tmpl := range result.Templates {
+		if strings.HasPrefix(tmpl.Template, "at ") {
+			t.Errorf("unexpected continuation template: %s", tmpl.Template)
+		}
+	}
+	if result.Events[1].TemplateID != result.Events[3].TemplateID {
+		t.Errorf("stack traces got different templates")
+	}
+}
+
+func TestMultilineHeaderRegex(t *testing.T) {
+	// Continuation lines with as many words as the header format
+	input := `2024-01-15 10:30:25 ERROR Failed to save upload
+java.io.UncheckedIOException: disk quota exceeded
+	at com.example.Store.save(Store.java:10)
+Caused by: java.io.IOException: quota of 10 exceeded
+	at com.example.Main.main(Main.java:5)
+2024-01-15 10:30:26 INFO Retrying
+`
+	p, err := New(
+		WithHeaderRegex(`^(?P<Date>\d{4}-\d{2}-\d{2}) (?P<Time>\S+) (?P<Level>[A-Z]+) (?P<Content>.*)$`),
+		WithMultiline(""),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(result.Events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(result.Events))
+	}
+	// The header is parsed from the first line only
+	ev := result.Events[0]
+	if got := strings.Count(ev.RawContent, "\n"); got != 4 {
+		t.Errorf("first event has %d continuation lines, want 4", got)
+	}
+	if !ev.HeaderMatched || ev.Headers["Level"] != "ERROR" || !strings.HasPrefix(ev.RawContent, "Failed to save upload\n") {
+		t.Errorf("first event = %+v, want the header of its first line", ev)
+	}
+	if result.Stats.HeaderMismatches != 0 {
+		t.Errorf("HeaderMismatches = %d, want 0", result.Stats.HeaderMismatches)
+	}
+
+	// A separator-based format fits "Caused by: ..." as well, so it needs
+	// a start pattern
+	if _, err := New(WithHeaderFormat("<Date> <Time> <Level> <Content>"), WithMultiline("")); err == nil {
+		t.Error("New() error = nil for a header format without a start pattern")
+	}
+	if _, err := New(WithMultiline("")); err == nil {
+		t.Error("New() error = nil without a header format or start pattern")
+	}
+	if _, err := New(WithMultiline(""), WithJSONInput("msg")); err != nil {
+		t.Errorf("New() error = %v for structured input", err)
+	}
+}
+
+func TestMultilineStructuredInput(t *testing.T) {
+	const trace = "\tat com.example.Store.save(Store.java:10)\n\tat com.example.Main.main(Main.java:5)\n"
+	tests := []struct {
+		name   string
+		opt    Option
+		first  string
+		header string
+		value  string
+	}{
+		{
+			name:   "json",
+			opt:    WithJSONInput("msg"),
+			first:  `{"level":"error","msg":"Failed to save upload"}`,
+			header: "level", value: "error",
+		},
+		{
+			name:   "logfmt",
+			opt:    WithLogfmtInput("msg"),
+			first:  `level=error msg="Failed to save upload"`,
+			header: "level", value: "error",
+		},
+		{
+			name:   "syslog",
+			opt:    WithPreset("syslog-rfc3164"),
+			first:  "Jan 15 10:30:22 web01 app[2154]: Failed to save upload",
+			header: "App", value: "app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opt, WithMultiline(""))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			result, err := p.Parse(strings.NewReader(tt.first + "\n" + trace + tt.first + "\n"))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(result.Events) != 2 {
+				t.Fatalf("expected 2 events, got %d", len(result.Events))
+			}
+			ev := result.Events[0]
+			want := "Failed to save upload\n" + strings.TrimSuffix(trace, "\n")
+			if ev.RawContent != want || !ev.HeaderMatched || ev.Headers[tt.header] != tt.value {
+				t.Errorf("event = %q, matched %v, headers %v; want %q with %s=%s",
+					ev.RawContent, ev.HeaderMatched, ev.Headers, want, tt.header, tt.value)
+			}
+			if result.Stats.HeaderMismatches != 0 {
+				t.Errorf("HeaderMismatches = %d, want 0", result.Stats.HeaderMismatches)
+			}
+		})
+	}
+}
+
+func TestMultilineLimits(t *testing.T) {
+	input := "START event\nline 1\nline 2\nline 3\nline 4\nSTART next\n"
+
+	t.Run("max lines", func(t *testing.T) {
+		p, _ := New(WithMultiline(`^START`), WithMultilineLimits(3, 1024))
+		result, err := p.Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		ev := result.Events[0]
+		if ev.RawContent != "START event\nline 1\nline 2" {
+			t.Errorf("content = %q", ev.RawContent)
+		}
+		if ev.EndLine != 5 {
+			t.Errorf("EndLine = %d, want 5 (dropped lines still belong to the event)", ev.EndLine)
+		}
+		if len(result.Events) != 2 {
+			t.Errorf("expected 2 events, got %d", len(result.Events))
+		}
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		p, _ := New(WithMultiline(`^START`), WithMultilineLimits(100, 20))
+		result, err := p.Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if got := result.Events[0].RawContent; got != "START event\nline 1" {
+			t.Errorf("content = %q", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := New(WithMultilineLimits(0, 10)); err == nil {
+			t.Error("expected error for zero max lines")
+		}
+		if _, err := New(WithMultiline(`(`)); err == nil {
+			t.Error("expected error for invalid start pattern")
+		}
+	})
+}
+
+func TestSingleLineNumbers(t *testing.T) {
+	p, _ := New()
+	result, err := p.Parse(strings.NewReader("first\n\nsecond\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	ev := result.Events[1]
+	if ev.LineID != 2 || ev.StartLine != 3 || ev.EndLine != 3 {
+		t.Errorf("second event LineID=%d lines=%d-%d, want 2 and 3-3", ev.LineID, ev.StartLine, ev.EndLine)
+	}
+}
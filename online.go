@@ -0,0 +1,252 @@
+package ulp
+
+import (
+	"sort"
+	"strings"
+)
+
+// onlineState holds the incrementally maintained groups of the online mode.
+type onlineState struct {
+	lines    int // lines fed to Add, including skipped ones
+	lineID   int
+	groups   map[string]*groupState
+	fields   fieldSet       // header field names seen in structured input
+	partials *partialJoiner // pending parts of split container log messages
+
+	// Template changes not yet passed to the callback, and whether an Add
+	// call is passing them, see notify
+	changes   []TemplateChange
+	notifying bool
+}
+
+// groupState is the incremental counterpart of a LogGroup. Templates are
+// built from the first event's tokens, so only those tokens need to be
+// tracked: a token missing from any later event has a count below the group
+// size and stays dynamic for good. This gives the same template as the batch
+// algorithm without sampling while keeping memory proportional to the first
+// event rather than to the group size.
+type groupState struct {
+	eventID   string
+	firstLine int
+	tokens    []string            // tokens of the first event
+	static    map[string]struct{} // first-event tokens present in every event so far
+	count     int
+	template  string
+	seeded    bool // template given to Seed, which is never refined
+}
+
+// newGroupState starts a group from its first event.
+func newGroupState(ev *LogEvent) *groupState {
+	tokens := strings.Fields(ev.TokenString)
+	static := make(map[string]struct{}, len(tokens))
+	for _, tok := range tokens {
+		static[tok] = struct{}{}
+	}
+	return &groupState{
+		eventID:   ev.EventID,
+		firstLine: ev.LineID,
+		tokens:    tokens,
+		static:    static,
+		count:     1,
+	}
+}
+
+// add accounts for a subsequent event of the group and reports whether any
+// token became dynamic.
+func (g *groupState) add(ev *LogEvent) bool {
+	g.count++
+	if g.seeded || len(g.static) == 0 {
+		return false
+	}
+	seen := make(map[string]struct{}, len(g.static))
+	for _, tok := range strings.Fields(ev.TokenString) {
+		if _, ok := g.static[tok]; ok {
+			seen[tok] = struct{}{}
+		}
+	}
+	if len(seen) == len(g.static) {
+		return false
+	}
+	g.static = seen
+	return true
+}
+
+// buildTemplate renders the current template of the group.
+func (g *groupState) buildTemplate(wildcard string, replaceNumbers bool) string {
+	var b strings.Builder
+	for i, tok := range g.tokens {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if _, ok := g.static[tok]; ok {
+			b.WriteString(tok)
+		} else {
+			b.WriteString(wildcard)
+		}
+	}
+	return cleanupTemplate(b.String(), wildcard, replaceNumbers)
+}
+
+// Add feeds a single log line to the parser in online mode. The line is
+// preprocessed and assigned to its group, whose template is refined as soon
+// as a token turns out to be dynamic; the callback set with
+// WithTemplateChange is notified of every change, see notify. The returned
+// event carries its EventID and the parameters matched by the current group
+// template. Empty lines, and mismatched lines with HeaderMismatchSkip, are
+// skipped and yield nil. So do the parts of container log messages split
+// over several lines but the last one, whose event has the joined message.
+//
+// Add and Snapshot are safe for concurrent use and independent of Parse.
+func (p *Parser) Add(line string) *LogEvent {
+	if line == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	if p.online == nil {
+		p.online = &onlineState{groups: make(map[string]*groupState), fields: make(fieldSet)}
+	}
+	p.online.lines++
+	rec := rawRecord{text: line, startLine: p.online.lines, endLine: p.online.lines}
+	if in, ok := p.input.(partialInput); ok {
+		if p.online.partials == nil {
+			p.online.partials = &partialJoiner{in: in}
+		}
+		if rec, ok = p.online.partials.add(line, p.online.lines); !ok {
+			p.mu.Unlock()
+			return nil
+		}
+	}
+	ev := p.newEvent(rec)
+	if p.skipEvent(ev) {
+		p.mu.Unlock()
+		return nil
+	}
+	p.online.lineID++
+	ev.LineID = p.online.lineID
+	p.online.fields.add(ev.Headers)
+	ev.EventID = generateEventID(ev.TokenString)
+
+	var change *TemplateChange
+	g, ok := p.online.groups[ev.EventID]
+	if !ok {
+		g = newGroupState(ev)
+		g.template = g.buildTemplate(p.dynamicWildcard, p.replaceNumbers)
+		p.online.groups[ev.EventID] = g
+		change = &TemplateChange{EventID: ev.EventID, NewTemplate: g.template}
+	} else if g.add(ev) {
+		if tmpl := g.buildTemplate(p.dynamicWildcard, p.replaceNumbers); tmpl != g.template {
+			change = &TemplateChange{EventID: ev.EventID, OldTemplate: g.template, NewTemplate: tmpl}
+			g.template = tmpl
+		}
+	}
+	template := g.template
+	if change != nil && p.onTemplateChange != nil {
+		p.online.changes = append(p.online.changes, *change)
+		p.notify()
+	}
+	p.mu.Unlock()
+
+	if !p.skipParameters {
+		tokens := strings.Fields(ev.TokenString)
+		spans, _ := matchTemplate(strings.Fields(template), tokens, p.dynamicWildcard, p.placeholders)
+		ev.Parameters = locateParameters(ev, tokens, spans)
+	}
+	return ev
+}
+
+// notify passes the queued template changes to the callback in the order
+// they were made, one call at a time. The callback runs without p.mu held,
+// so it may call Add or Snapshot; meanwhile, changes made by concurrent Add
+// calls are queued and passed by the call already notifying, which may thus
+// report changes of other lines than its own. p.mu must be held.
+func (p *Parser) notify() {
+	if p.online.notifying {
+		return
+	}
+	p.online.notifying = true
+	for len(p.online.changes) > 0 {
+		change := p.online.changes[0]
+		p.online.changes = p.online.changes[1:]
+		p.mu.Unlock()
+		p.onTemplateChange(change)
+		p.mu.Lock()
+	}
+	p.online.changes = nil
+	p.online.notifying = false
+}
+
+// Seed primes online mode with previously learned templates, such as
+// ParseResult.Templates or the templates of a saved model. Add then assigns
+// lines of known events to them and reports only new templates to the
+// WithTemplateChange callback. Seeded templates are frozen: the tokens of
+// the events they were learned from aren't kept, so they are never refined,
+// and lines of their groups that don't fit them get no parameters. Templates
+// of groups already known to the online mode are ignored.
+func (p *Parser) Seed(templates []*LogTemplate) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.online == nil {
+		p.online = &onlineState{groups: make(map[string]*groupState), fields: make(fieldSet)}
+	}
+
+	for _, tmpl := range templates {
+		for i, eid := range tmpl.EventIDs {
+			if _, ok := p.online.groups[eid]; ok {
+				continue
+			}
+			g := &groupState{eventID: eid, template: tmpl.Template, seeded: true}
+			// Merged templates don't keep per-group counts; since seeded
+			// templates don't change, the groups are merged again by
+			// Snapshot and the count adds up
+			if i == 0 {
+				g.count = tmpl.Count
+			}
+			p.online.groups[eid] = g
+		}
+	}
+}
+
+// Snapshot returns the templates learned so far in online mode. Groups are
+// reported with their Count and current Template but without Events, which
+// aren't retained. Templates are merged and numbered as in Parse.
+func (p *Parser) Snapshot() *ParseResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := &ParseResult{HeaderFields: p.headerFields(nil)}
+	if p.online == nil {
+		return result
+	}
+	result.HeaderFields = p.headerFields(p.online.fields)
+
+	result.Groups = groupsFromStates(p.online.groups)
+	result.Templates = mergeGroupsWithSimilarTemplates(result.Groups, p.dynamicWildcard)
+	return result
+}
+
+// groupsFromStates converts group states into LogGroups ordered by the line
+// of their first event. Events are not retained; only Count is set.
+func groupsFromStates(states map[string]*groupState) []*LogGroup {
+	sorted := make([]*groupState, 0, len(states))
+	for _, g := range states {
+		sorted = append(sorted, g)
+	}
+	// Seeded groups have no first line and are ordered by EventID
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].firstLine != sorted[j].firstLine {
+			return sorted[i].firstLine < sorted[j].firstLine
+		}
+		return sorted[i].eventID < sorted[j].eventID
+	})
+
+	groups := make([]*LogGroup, 0, len(sorted))
+	for _, g := range sorted {
+		groups = append(groups, &LogGroup{
+			EventID:  g.eventID,
+			Count:    g.count,
+			Template: g.template,
+		})
+	}
+	return groups
+}
@@ -0,0 +1,266 @@
+package ulp
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnlineMatchesBatch(t *testing.T) {
+	data, err := os.ReadFile("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+	format := "<Date> <Time> <Pid> <Level> <Component>: <Content>"
+
+	batch, _ := New(WithHeaderFormat(format))
+	want, err := batch.Parse(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	online, _ := New(WithHeaderFormat(format))
+	for _, line := range strings.Split(string(data), "\n") {
+		online.Add(line)
+	}
+	got := online.Snapshot()
+
+	if !reflect.DeepEqual(got.Templates, want.Templates) {
+		t.Errorf("online templates differ from batch:\n got %+v\nwant %+v", got.Templates, want.Templates)
+	}
+	if len(got.Groups) != len(want.Groups) {
+		t.Errorf("expected %d groups, got %d", len(want.Groups), len(got.Groups))
+	}
+	if got.Events != nil {
+		t.Errorf("Snapshot() should not retain events")
+	}
+}
+
+func TestOnlineTemplateChange(t *testing.T) {
+	var changes []TemplateChange
+	p, err := New(WithTemplateChange(func(c TemplateChange) {
+		changes = append(changes, c)
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	p.Add("session 42 started")
+	p.Add("session 42 started")
+	ev := p.Add("session 7 started")
+	p.Add("session 9 started")
+
+	want := []TemplateChange{
+		{EventID: "sessionstarted3", NewTemplate: "session 42 started"},
+		{EventID: "sessionstarted3", OldTemplate: "session 42 started", NewTemplate: "session <*> started"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+
+	if ev.LineID != 3 {
+		t.Errorf("event LineID = %d, want 3", ev.LineID)
+	}
+	if len(ev.Parameters) != 1 || ev.Parameters[0].Value != "7" {
+		t.Errorf("event parameters = %v, want [7]", ev.Parameters)
+	}
+
+	snap := p.Snapshot()
+	if len(snap.Templates) != 1 || snap.Templates[0].Count != 4 {
+		t.Errorf("Snapshot() templates = %+v, want one template with count 4", snap.Templates)
+	}
+}
+
+func TestOnlineEmpty(t *testing.T) {
+	p, _ := New()
+	if ev := p.Add(""); ev != nil {
+		t.Errorf("Add(\"\") = %+v, want nil", ev)
+	}
+	snap := p.Snapshot()
+	if len(snap.Templates) != 0 || len(snap.Groups) != 0 {
+		t.Errorf("Snapshot() = %+v, want empty", snap)
+	}
+}
+
+func TestOnlineConcurrentAdd(t *testing.T) {
+	p, _ := New()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Go(func() {
+			for i := 0; i < 100; i++ {
+				p.Add("worker task " + intToStr(i) + " done")
+				p.Snapshot()
+			}
+		})
+	}
+	wg.Wait()
+
+	snap := p.Snapshot()
+	if len(snap.Templates) != 1 || snap.Templates[0].Count != 400 {
+		t.Errorf("Snapshot() templates = %+v, want one template with count 400", snap.Templates)
+	}
+}
+
+func TestOnlineTemplateChangeOrder(t *testing.T) {
+	var (
+		running atomic.Int32
+		changes []TemplateChange
+		p       *Parser
+	)
+	p, err := New(WithTemplateChange(func(c TemplateChange) {
+		if running.Add(1) > 1 {
+			t.Error("callback calls overlap")
+		}
+		changes = append(changes, c)
+		p.Snapshot()                       // must not deadlock
+		time.Sleep(100 * time.Microsecond) // let other Add calls make changes
+		running.Add(-1)
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	jobs := []string{"build", "deploy", "test"}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Go(func() {
+			for i := 0; i < 50; i++ {
+				p.Add(jobs[i%len(jobs)] + " job " + intToStr(i%5) + " step " + intToStr(w) + " done")
+			}
+		})
+	}
+	wg.Wait()
+
+	// Every change must start from the template of the previous one, and
+	// the last one must be the final template
+	last := make(map[string]string)
+	for _, c := range changes {
+		if c.OldTemplate != last[c.EventID] {
+			t.Fatalf("change %+v after template %q", c, last[c.EventID])
+		}
+		last[c.EventID] = c.NewTemplate
+	}
+	groups := p.Snapshot().Groups
+	if len(groups) != len(jobs) {
+		t.Fatalf("Snapshot() has %d groups, want %d", len(groups), len(jobs))
+	}
+	for _, g := range groups {
+		if last[g.EventID] != g.Template {
+			t.Errorf("last change of %s = %q, want %q", g.EventID, last[g.EventID], g.Template)
+		}
+	}
+}
+
+func TestOnlineSeed(t *testing.T) {
+	format := "<Date> <Time> <Pid> <Level> <Component>: <Content>"
+	batch, _ := New(WithHeaderFormat(format))
+	data, err := os.ReadFile("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+	learned, err := batch.Parse(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var changes []TemplateChange
+	p, _ := New(WithHeaderFormat(format), WithTemplateChange(func(c TemplateChange) {
+		changes = append(changes, c)
+	}))
+	p.Seed(learned.Templates)
+
+	// A line of a known event is classified without any change
+	ev := p.Add("081109 204005 35 INFO dfs.FSNamesystem: BLOCK* NameSystem.addStoredBlock: blockMap updated: 10.251.73.220:50010 is added to blk_7128370237687728475 size 67108864")
+	if ev == nil || len(changes) != 0 {
+		t.Fatalf("Add() of a known event: event %v, changes %+v", ev, changes)
+	}
+	if n := len(ev.Parameters); n == 0 || ev.Parameters[n-1].Value != "blk_7128370237687728475" {
+		t.Errorf("Add() parameters = %+v", ev.Parameters)
+	}
+
+	// A new event is reported
+	p.Add("081109 204005 35 WARN dfs.DataNode: Disk 3 failed")
+	if len(changes) != 1 || changes[0].OldTemplate != "" || changes[0].NewTemplate != "Disk 3 failed" {
+		t.Errorf("changes = %+v, want the new template", changes)
+	}
+
+	snap := p.Snapshot()
+	if len(snap.Templates) != len(learned.Templates)+1 {
+		t.Fatalf("Snapshot() has %d templates, want %d", len(snap.Templates), len(learned.Templates)+1)
+	}
+	counts := make(map[string]int)
+	for _, tmpl := range snap.Templates {
+		counts[tmpl.Template] = tmpl.Count
+	}
+	for _, tmpl := range learned.Templates {
+		want := tmpl.Count
+		if strings.HasPrefix(tmpl.Template, "BLOCK*") {
+			want++
+		}
+		if counts[tmpl.Template] != want {
+			t.Errorf("template %q count = %d, want %d", tmpl.Template, counts[tmpl.Template], want)
+		}
+	}
+}
+
+func TestOnlineSeedFrozen(t *testing.T) {
+	format := "<Date> <Time> <Pid> <Level> <Component>: <Content>"
+	learned := parseFile(t, "testdata/hdfs_sample.log", WithHeaderFormat(format))
+
+	var changes []TemplateChange
+	p, _ := New(WithHeaderFormat(format), WithTemplateChange(func(c TemplateChange) {
+		changes = append(changes, c)
+	}))
+	p.Seed(learned.Templates)
+
+	// A line of a seeded group that would refine its template
+	const tmpl = "Received block <*> of size 67108864 from <*>"
+	ev := p.Add("081109 204005 35 INFO dfs.DataNode$PacketResponder: Received block blk_1 of size 1024 from /10.0.0.1")
+	if len(changes) != 0 {
+		t.Errorf("changes = %+v, want none for a seeded group", changes)
+	}
+	if ev == nil || ev.Parameters != nil {
+		t.Errorf("Add() = %+v, want an event without parameters", ev)
+	}
+
+	var want int
+	for _, lt := range learned.Templates {
+		if lt.Template == tmpl {
+			want = lt.Count + 1
+		}
+	}
+	if want == 0 {
+		t.Fatalf("no template %q learned", tmpl)
+	}
+	for _, lt := range p.Snapshot().Templates {
+		if lt.Template == tmpl && lt.Count != want {
+			t.Errorf("template %q count = %d, want %d", tmpl, lt.Count, want)
+		}
+	}
+}
+
+func TestOnlinePartialLines(t *testing.T) {
+	p, err := New(WithPreset("cri"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	lines := []string{
+		"2024-01-15T10:30:22.000000001Z stdout P upload of ",
+		"2024-01-15T10:30:22.000000002Z stderr F retrying",
+		"2024-01-15T10:30:22.000000003Z stdout F 42 bytes done",
+	}
+	var got []string
+	for _, line := range lines {
+		if ev := p.Add(line); ev != nil {
+			got = append(got, ev.Headers["Stream"]+": "+ev.RawContent)
+		}
+	}
+	want := []string{"stderr: retrying", "stdout: upload of 42 bytes done"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
@@ -2,19 +2,60 @@ package ulp
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"regexp"
 	"runtime"
+	"strings"
+	"sync"
+	"unicode"
 )
 
 // Parser is the main ULP log parser.
 type Parser struct {
-	headerFormat    *HeaderFormat
-	contentField    string
-	customRegex     []*regexp.Regexp
-	sampleSize      int
-	maxWorkers      int
-	dynamicWildcard string
-	replaceNumbers  bool
+	headerFormat     *HeaderFormat
+	contentField     string
+	customRegex      []*regexp.Regexp
+	patterns         []Pattern // named patterns, see WithPattern
+	extendedPatterns []string  // see WithExtendedPatterns
+	disabledPatterns []string  // see WithDisabledPatterns
+	sampleSize       int
+	maxWorkers       int
+	dynamicWildcard  string
+	replaceNumbers   bool
+	skipParameters   bool // see WithParameters
+	progress         func(Progress)
+	rejects          io.Writer
+	headerMismatch   HeaderMismatchPolicy
+	input            inputDecoder // structured input, replaces headerFormat
+	foldKeys         bool
+
+	// preprocessing patterns in application order, those whose matches
+	// keep their punctuation and, with typed placeholders, the placeholder
+	// types by token; set by initPatterns
+	typedPlaceholders bool
+	rules             []Pattern
+	rawRules          []Pattern
+	placeholders      map[string]string
+
+	// content preprocessing, see WithPreprocessor; preprocessor is set by
+	// initPreprocessor
+	punctuation       string
+	brackets          string
+	tokenizer         Tokenizer
+	preprocessorWraps []func(Preprocessor) Preprocessor
+	preprocessor      Preprocessor
+
+	// multi-line event aggregation, see WithMultiline
+	multiline         bool
+	multilineStart    *regexp.Regexp
+	multilineMaxLines int
+	multilineMaxBytes int
+
+	// online mode state, see Add and Snapshot
+	mu               sync.Mutex
+	online           *onlineState
+	onTemplateChange func(TemplateChange)
 }
 
 // Option configures the Parser.
@@ -23,21 +64,35 @@ type Option func(*Parser) error
 // New creates a new Parser with the given options.
 func New(opts ...Option) (*Parser, error) {
 	p := &Parser{
-		contentField:    "Content",
-		sampleSize:      0,
-		maxWorkers:      runtime.NumCPU(),
-		dynamicWildcard: "<*>",
+		contentField:      "Content",
+		sampleSize:        0,
+		maxWorkers:        runtime.NumCPU(),
+		dynamicWildcard:   "<*>",
+		punctuation:       defaultPunctuation,
+		brackets:          defaultBrackets,
+		tokenizer:         WhitespaceTokenizer,
+		multilineMaxLines: defaultMultilineMaxLines,
+		multilineMaxBytes: defaultMultilineMaxBytes,
 	}
 	for _, opt := range opts {
 		if err := opt(p); err != nil {
 			return nil, err
 		}
 	}
+	if err := p.validateMultiline(); err != nil {
+		return nil, err
+	}
+	if err := p.initPatterns(); err != nil {
+		return nil, err
+	}
+	p.initPreprocessor()
 	return p, nil
 }
 
 // WithHeaderFormat sets the log header format string.
 // Example: "<Date> <Time> <Pid> <Level> <Component>: <Content>"
+// The content field takes the rest of the line, so it must be the last
+// field; use WithHeaderRegex with HeaderFormatRegex for other formats.
 func WithHeaderFormat(format string) Option {
 	return func(p *Parser) error {
 		hf, err := parseHeaderFormat(format, p.contentField)
@@ -49,6 +104,104 @@ func WithHeaderFormat(format string) Option {
 	}
 }
 
+// WithHeaderRegex sets a regular expression with named groups for parsing
+// the log header, e.g. `^\[(?P<Time>[^\]]+)\] (?P<Level>\w+)\s+(?P<Content>.*)$`.
+// One group must be named after the content field, so WithContentField has
+// to come first if a custom name is used. Lines that don't match are kept
+// whole as content and counted in ParseStats.HeaderMismatches.
+// Use HeaderFormatRegex to build the pattern from "<Field>" syntax.
+func WithHeaderRegex(pattern string) Option {
+	return func(p *Parser) error {
+		hf, err := parseHeaderRegex(pattern, p.contentField)
+		if err != nil {
+			return err
+		}
+		p.headerFormat = hf
+		return nil
+	}
+}
+
+// WithPreset configures the parser for a well-known log format listed in
+// Presets, e.g. "syslog-rfc3164", "cri" or the Loghub datasets "HDFS", "Spark",
+// "OpenSSH". Names are case-insensitive. Loghub presets set a header regex
+// built from the preset format, whose content field is always "Content"
+// whatever is set with WithContentField, and add the preset patterns to any
+// set with WithCustomRegex.
+func WithPreset(name string) Option {
+	return func(p *Parser) error {
+		preset, ok := lookupPreset(name)
+		if !ok {
+			names := make([]string, len(Presets))
+			for i, pr := range Presets {
+				names[i] = pr.Name
+			}
+			return fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(names, ", "))
+		}
+
+		if preset.input != nil {
+			p.input = preset.input
+		} else {
+			pattern, err := formatRegex(preset.Format, false)
+			if err != nil {
+				return fmt.Errorf("preset %s: %w", preset.Name, err)
+			}
+			hf, err := parseHeaderRegex(pattern, "Content")
+			if err != nil {
+				return fmt.Errorf("preset %s: %w", preset.Name, err)
+			}
+			p.headerFormat = hf
+		}
+		if err := WithCustomRegex(preset.Regex)(p); err != nil {
+			return fmt.Errorf("preset %s: %w", preset.Name, err)
+		}
+		return nil
+	}
+}
+
+// WithJSONInput parses each line as a JSON object. The message at the
+// dotted messagePath (e.g. "msg" or "log.message") becomes the content and
+// the other top-level fields become header fields, with other values than
+// strings and null kept as compact JSON. Lines that aren't JSON objects or
+// lack the message are kept whole as content and count as header
+// mismatches. This replaces any header format.
+func WithJSONInput(messagePath string) Option {
+	return func(p *Parser) error {
+		in, err := newJSONInput(messagePath)
+		if err != nil {
+			return err
+		}
+		p.input = in
+		return nil
+	}
+}
+
+// WithLogfmtInput parses each line as logfmt, e.g.
+// `level=info ts=2024-01-15T10:30:22Z msg="connection reset" peer=10.0.0.1`.
+// The value of messageKey becomes the content and the other pairs become
+// header fields. Lines without key=value pairs or without the message are
+// kept whole as content and count as header mismatches.
+// This replaces any header format.
+func WithLogfmtInput(messageKey string) Option {
+	return func(p *Parser) error {
+		if messageKey == "" {
+			return fmt.Errorf("logfmt message key cannot be empty")
+		}
+		p.input = &logfmtInput{key: messageKey}
+		return nil
+	}
+}
+
+// WithFoldKeys appends the header fields of structured input (JSON or
+// logfmt) to the content tokens as "key=<*>", so that messages carrying
+// different sets of fields get different templates. RawContent is not
+// changed, nor are lines kept whole as content. Default: false.
+func WithFoldKeys(enable bool) Option {
+	return func(p *Parser) error {
+		p.foldKeys = enable
+		return nil
+	}
+}
+
 // WithContentField sets the name of the header field that contains
 // the log message body. Default is "Content".
 func WithContentField(field string) Option {
@@ -76,6 +229,145 @@ func WithCustomRegex(patterns []string) Option {
 	}
 }
 
+// WithPattern adds a named preprocessing pattern, applied after the built-in
+// ones and in the order added. Matched tokens are replaced with placeholder,
+// e.g. "<SESSION>", if typed placeholders are enabled, and with the dynamic
+// wildcard otherwise or if placeholder is empty. The name cannot be that of
+// a built-in pattern, default or extended.
+func WithPattern(name, pattern, placeholder string) Option {
+	return withPattern(name, pattern, placeholder, 0)
+}
+
+// WithPatternFile adds the patterns of a pattern pack, a JSON array of
+// entries like
+//
+//	{"name": "session", "regex": "sess-[0-9a-f]{8}", "placeholder": "<SESSION>", "priority": 10}
+//
+// Only name and regex are required. Entries with a positive priority are
+// applied before the built-in patterns, higher priorities first; the others
+// after them, like WithPattern. An entry with "enabled": false is skipped.
+// An entry with no regex names a built-in pattern and turns it on, like
+// WithExtendedPatterns, or off with "enabled": false. The file is validated
+// when it is loaded; errors name the offending entry and its line.
+func WithPatternFile(path string) Option {
+	return func(p *Parser) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("pattern file: %w", err)
+		}
+		patterns, extended, disabled, err := parsePatternPack(data)
+		if err != nil {
+			return fmt.Errorf("pattern file %s: %w", path, err)
+		}
+		p.patterns = append(p.patterns, patterns...)
+		p.extendedPatterns = append(p.extendedPatterns, extended...)
+		p.disabledPatterns = append(p.disabledPatterns, disabled...)
+		return nil
+	}
+}
+
+// WithTypedPlaceholders replaces tokens matched by a pattern with the
+// pattern's typed placeholder, such as "<IP>" or "<DATE>", instead of the
+// dynamic wildcard. Placeholders are kept in templates, and the parameters
+// they cover carry their type. Since placeholders are words, lines with
+// values of different types fall into different groups. Default is false,
+// as in the paper.
+func WithTypedPlaceholders(enable bool) Option {
+	return func(p *Parser) error {
+		p.typedPlaceholders = enable
+		return nil
+	}
+}
+
+// WithExtendedPatterns turns on ExtendedPatterns by name, e.g. "ipv4" or
+// "duration", or all of them if no names are given.
+func WithExtendedPatterns(names ...string) Option {
+	return func(p *Parser) error {
+		if len(names) == 0 {
+			for _, pat := range ExtendedPatterns {
+				names = append(names, pat.Name)
+			}
+		}
+		for _, name := range names {
+			if name == "" {
+				return fmt.Errorf("pattern name cannot be empty")
+			}
+		}
+		p.extendedPatterns = append(p.extendedPatterns, names...)
+		return nil
+	}
+}
+
+// WithDisabledPatterns turns off preprocessing patterns by name, e.g.
+// "date" or "host". Names are those of DefaultPatterns and ExtendedPatterns,
+// of patterns added with WithPattern and "custom-N" for the N-th regex of
+// WithCustomRegex.
+func WithDisabledPatterns(names ...string) Option {
+	return func(p *Parser) error {
+		for _, name := range names {
+			if name == "" {
+				return fmt.Errorf("pattern name cannot be empty")
+			}
+		}
+		p.disabledPatterns = append(p.disabledPatterns, names...)
+		return nil
+	}
+}
+
+// WithPreprocessor changes how content is turned into tokens. wrap receives
+// the preprocessor configured so far, the default one or the result of an
+// earlier WithPreprocessor, and returns the one to use. It may call next
+// before or after its own steps to chain them, or ignore it to replace the
+// default behavior altogether. wrap is called once all options are set.
+// Custom preprocessors are not saved in models; pass them to LoadModel again.
+func WithPreprocessor(wrap func(next Preprocessor) Preprocessor) Option {
+	return func(p *Parser) error {
+		if wrap == nil {
+			return fmt.Errorf("preprocessor cannot be nil")
+		}
+		p.preprocessorWraps = append(p.preprocessorWraps, wrap)
+		return nil
+	}
+}
+
+// WithTokenizer sets how the default preprocessor splits normalized content
+// into tokens. Default is WhitespaceTokenizer. Custom tokenizers are not
+// saved in models; pass them to LoadModel again.
+func WithTokenizer(t Tokenizer) Option {
+	return func(p *Parser) error {
+		if t == nil {
+			return fmt.Errorf("tokenizer cannot be nil")
+		}
+		p.tokenizer = t
+		return nil
+	}
+}
+
+// WithPunctuation sets the characters the default preprocessor removes from
+// content, "!@#$%^&{}<>?\\|`~" by default. An empty string keeps all of them.
+func WithPunctuation(chars string) Option {
+	return func(p *Parser) error {
+		if strings.ContainsFunc(chars, unicode.IsSpace) {
+			return fmt.Errorf("punctuation cannot contain whitespace")
+		}
+		p.punctuation = chars
+		return nil
+	}
+}
+
+// WithBrackets sets the characters the default preprocessor surrounds with
+// spaces, making them tokens of their own, "=()[]" by default. An empty
+// string leaves them attached to their neighbors.
+func WithBrackets(chars string) Option {
+	return func(p *Parser) error {
+		if strings.ContainsFunc(chars, unicode.IsSpace) {
+			return fmt.Errorf("brackets cannot contain whitespace")
+		}
+		p.brackets = chars
+		return nil
+	}
+}
+
 // WithSampleSize sets the maximum number of events sampled per group
 // for frequency analysis. 0 means use all events.
 func WithSampleSize(n int) Option {
@@ -89,7 +381,8 @@ func WithSampleSize(n int) Option {
 }
 
 // WithMaxWorkers sets the number of worker goroutines for parallel
-// group processing. 0 or negative values default to runtime.NumCPU().
+// preprocessing and group processing. 0 or negative values default to
+// runtime.NumCPU().
 func WithMaxWorkers(n int) Option {
 	return func(p *Parser) error {
 		if n <= 0 {
@@ -122,3 +415,88 @@ func WithReplaceNumbers(enable bool) Option {
 		return nil
 	}
 }
+
+// WithParameters sets whether the Parameters of events are extracted by
+// Parse, StreamEvents, MatchAll and Add. Turning it off saves aligning every
+// event with its template when only the templates are needed. Default: true.
+func WithParameters(enable bool) Option {
+	return func(p *Parser) error {
+		p.skipParameters = !enable
+		return nil
+	}
+}
+
+// WithTemplateChange sets a callback invoked by Add whenever a group's
+// template is created or refined in online mode. Changes are passed in the
+// order they were made and calls never overlap, even with concurrent Add
+// calls, but a change may be passed by another Add call than the one that
+// made it.
+func WithTemplateChange(fn func(TemplateChange)) Option {
+	return func(p *Parser) error {
+		p.onTemplateChange = fn
+		return nil
+	}
+}
+
+// WithProgress sets a callback that receives periodic progress reports
+// while reading input and generating templates. Calls are serialized.
+func WithProgress(fn func(Progress)) Option {
+	return func(p *Parser) error {
+		p.progress = fn
+		return nil
+	}
+}
+
+// WithMultiline enables multi-line events such as stack traces. A line
+// starting a new event is one matching startPattern; any other line is
+// appended to the previous event. startPattern may be empty with a header
+// regex or a structured input format, whose records start events; a
+// separator-based header format can't tell continuation lines apart.
+func WithMultiline(startPattern string) Option {
+	return func(p *Parser) error {
+		re, err := compileMultilineStart(startPattern)
+		if err != nil {
+			return fmt.Errorf("invalid multiline start pattern %q: %w", startPattern, err)
+		}
+		p.multiline = true
+		p.multilineStart = re
+		return nil
+	}
+}
+
+// WithMultilineLimits caps the number of lines and bytes of a multi-line
+// event. Continuation lines beyond either cap are dropped. Defaults are
+// 500 lines and 64 KiB.
+func WithMultilineLimits(maxLines, maxBytes int) Option {
+	return func(p *Parser) error {
+		if maxLines <= 0 || maxBytes <= 0 {
+			return fmt.Errorf("multiline limits must be positive")
+		}
+		p.multilineMaxLines = maxLines
+		p.multilineMaxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithHeaderMismatch sets what happens to lines that don't fit the header
+// format. Mismatched lines are flagged with LogEvent.HeaderMatched and
+// counted in ParseStats regardless of the policy. Default: HeaderMismatchKeep.
+func WithHeaderMismatch(policy HeaderMismatchPolicy) Option {
+	return func(p *Parser) error {
+		if policy < HeaderMismatchKeep || policy > HeaderMismatchSkip {
+			return fmt.Errorf("unknown header mismatch policy %d", policy)
+		}
+		p.headerMismatch = policy
+		return nil
+	}
+}
+
+// WithRejectWriter sets a destination for lines (or multi-line events)
+// whose header didn't match the header format. Each is written followed by
+// a newline, in input order; with ParseFiles, file by file in path order.
+func WithRejectWriter(w io.Writer) Option {
+	return func(p *Parser) error {
+		p.rejects = w
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package ulp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// patternEntry is a pattern in a pattern pack file.
+type patternEntry struct {
+	Name        string `json:"name"`
+	Regex       string `json:"regex"`
+	Placeholder string `json:"placeholder"`
+	Enabled     *bool  `json:"enabled"` // default true
+	Priority    int    `json:"priority"`
+}
+
+// parsePatternPack parses and validates a pattern pack, returning its
+// enabled patterns, the extended patterns it turns on and the built-in
+// patterns it turns off.
+func parsePatternPack(data []byte) (patterns []Pattern, extended, disabled []string, err error) {
+	isExtended := make(map[string]bool, len(ExtendedPatterns))
+	for _, pat := range ExtendedPatterns {
+		isExtended[pat.Name] = true
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, nil, jsonPosError(data, err)
+	} else if tok != json.Delim('[') {
+		return nil, nil, nil, fmt.Errorf("want a JSON array of patterns")
+	}
+	seen := make(map[string]int)
+	for i := 1; dec.More(); i++ {
+		// Skip the separator so that the entry's line is that of its "{"
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(", \t\r\n"), data[offset]) >= 0 {
+			offset++
+		}
+		line, _ := lineCol(data, offset)
+		entryErr := func(format string, args ...any) error {
+			return fmt.Errorf("entry %d at line %d: %s", i, line, fmt.Sprintf(format, args...))
+		}
+		var e patternEntry
+		if err := dec.Decode(&e); err != nil {
+			if posErr := jsonPosError(data, err); posErr != err {
+				return nil, nil, nil, fmt.Errorf("entry %d: %w", i, posErr)
+			}
+			return nil, nil, nil, entryErr("%v", err)
+		}
+
+		switch {
+		case e.Name == "":
+			return nil, nil, nil, entryErr("missing name")
+		case seen[e.Name] > 0:
+			return nil, nil, nil, entryErr("duplicate name %q, first used by entry %d", e.Name, seen[e.Name])
+		}
+		seen[e.Name] = i
+
+		enabled := e.Enabled == nil || *e.Enabled
+		if e.Regex == "" {
+			switch {
+			case !isBuiltinPattern(e.Name) && enabled:
+				return nil, nil, nil, entryErr("pattern %s: missing regex", e.Name)
+			case !isBuiltinPattern(e.Name):
+				return nil, nil, nil, entryErr("pattern %s: no regex and not a built-in pattern to disable", e.Name)
+			case !enabled:
+				disabled = append(disabled, e.Name)
+			case isExtended[e.Name]:
+				extended = append(extended, e.Name)
+			}
+			continue
+		}
+		pat, err := compilePattern(e.Name, e.Regex, e.Placeholder)
+		if err != nil {
+			return nil, nil, nil, entryErr("%v", err)
+		}
+		if enabled {
+			pat.Priority = e.Priority
+			patterns = append(patterns, pat)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, nil, jsonPosError(data, err)
+	}
+	return patterns, extended, disabled, nil
+}
+
+// jsonPosError adds the line and column to JSON errors that have an offset,
+// that of the last byte read before the error.
+func jsonPosError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := lineCol(data, max(syntaxErr.Offset-1, 0))
+		return fmt.Errorf("line %d, column %d: %w", line, col, err)
+	case errors.As(err, &typeErr):
+		line, col := lineCol(data, max(typeErr.Offset-1, 0))
+		return fmt.Errorf("line %d, column %d: %w", line, col, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("unexpected end of file")
+	}
+	return err
+}
+
+// lineCol returns the 1-based line and column of a byte offset in data.
+func lineCol(data []byte, offset int64) (line, col int) {
+	offset = min(offset, int64(len(data)))
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
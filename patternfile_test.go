@@ -0,0 +1,97 @@
+package ulp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithPatternFile(t *testing.T) {
+	p, err := New(WithPatternFile("testdata/patterns.json"), WithTypedPlaceholders(true))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"opened sess-0a1b2c3d", "opened <SESSION>"},
+		// Applied before the built-in patterns, so the comma and the
+		// digits are not taken apart
+		{"placed ORD-1,234 of 2.5MB", "placed <ORDER> of <SIZE>"},
+		{"closed TCK-42", "closed TCK-42"},
+		{"took 30s", "took 30s"},
+	}
+	for _, tt := range tests {
+		if got := p.preprocess(tt.input); got != tt.want {
+			t.Errorf("preprocess(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	var names []string
+	for _, pat := range p.rules {
+		names = append(names, pat.Name)
+	}
+	if names[0] != "order" || names[len(names)-1] != "session" {
+		t.Errorf("pattern order = %v, want order first and session last", names)
+	}
+}
+
+func TestModelRoundTripPatternFile(t *testing.T) {
+	p, err := New(WithPatternFile("testdata/patterns.json"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.SaveModel(&buf, nil); err != nil {
+		t.Fatalf("SaveModel() error = %v", err)
+	}
+	loaded, _, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+	line := "placed ORD-1,234 by sess-0a1b2c3d in 30s"
+	if got, want := loaded.preprocess(line), p.preprocess(line); got != want {
+		t.Errorf("loaded parser preprocess = %q, want %q", got, want)
+	}
+}
+
+func TestWithPatternFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"not an array", `{"name": "a", "regex": "x"}`, "want a JSON array"},
+		{"syntax", "[\n  {\"name\": \"a\", \"regex\": \"x\"}\n  {\"name\": \"b\"}\n]", "line 3, column 3"},
+		{"truncated", `[{"name": "a", "regex": "x"},`, "entry 2"},
+		{"wrong type", "[\n  {\"name\": \"a\", \"regex\": \"x\", \"priority\": \"high\"}\n]", "entry 1: line 2"},
+		{"unknown field", "[\n  {\"name\": \"a\", \"regex\": \"x\"},\n  {\"name\": \"b\", \"regx\": \"y\"}\n]", `entry 2 at line 3: json: unknown field "regx"`},
+		{"missing name", `[{"regex": "x"}]`, "entry 1 at line 1: missing name"},
+		{"missing regex", `[{"name": "a"}]`, "pattern a: missing regex"},
+		{"invalid regex", "[\n\n  {\"name\": \"a\", \"regex\": \"(\"}\n]", `entry 1 at line 3: pattern a: invalid regex "("`},
+		{"invalid placeholder", `[{"name": "a", "regex": "x", "placeholder": "A"}]`, "invalid placeholder"},
+		{"duplicate", `[{"name": "a", "regex": "x"}, {"name": "a", "regex": "y"}]`, `entry 2 at line 1: duplicate name "a", first used by entry 1`},
+		{"built-in name", `[{"name": "host", "regex": "x"}]`, "entry 1 at line 1: pattern host: name is taken by a built-in pattern"},
+		{"extended name", `[{"name": "ipv4", "regex": "x"}]`, "entry 1 at line 1: pattern ipv4: name is taken by a built-in pattern"},
+		{"disable unknown", `[{"name": "a", "enabled": false}]`, "not a built-in pattern"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "pack.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := New(WithPatternFile(path))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), path) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := New(WithPatternFile(filepath.Join(dir, "missing.json"))); err == nil {
+		t.Error("New() error = nil for a missing file")
+	}
+}
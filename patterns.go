@@ -0,0 +1,192 @@
+package ulp
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Pattern is a named preprocessing rule for obvious dynamic tokens. Matches
+// of Regex are replaced with the dynamic wildcard, or with Placeholder if
+// typed placeholders are enabled with WithTypedPlaceholders.
+type Pattern struct {
+	Name        string
+	Regex       *regexp.Regexp
+	Placeholder string // typed placeholder such as "<IP>"; empty means the dynamic wildcard
+	Priority    int    // patterns with a higher priority are applied first; built-ins have 0
+}
+
+// DefaultPatterns are the built-in patterns applied by default, in order
+// from most specific to most general, so that e.g. a date is replaced before
+// the host it looks like. Each can be turned off by name with
+// WithDisabledPatterns.
+var DefaultPatterns = []Pattern{
+	{"mac", regexp.MustCompile(`([\da-fA-F]{2}:){5}[\da-fA-F]{2}`), "<MAC>", 0},
+	{"date", regexp.MustCompile(`\d{4}-\d{2}-\d{2}`), "<DATE>", 0},
+	{"date-slash", regexp.MustCompile(`\d{4}/\d{2}/\d{2}`), "<DATE>", 0},
+	{"time", regexp.MustCompile(`[0-9]{2}:[0-9]{2}:[0-9]{2}(?:[.,][0-9]{3})?`), "<TIME>", 0},
+	{"hex", regexp.MustCompile(`0[xX][0-9a-fA-F]+`), "<HEX>", 0},
+	{"ipv6", regexp.MustCompile(`([0-9a-fA-F]*:){8,}`), "<IP>", 0},
+	{"url", regexp.MustCompile(`https?://\S+`), "<URL>", 0},
+	{"host", regexp.MustCompile(`(/?)([a-zA-Z0-9-]+\.){2,}([a-zA-Z0-9-]+)?`), "<HOST>", 0},
+}
+
+// ExtendedPatterns are built-in patterns that are off by default, since they
+// change the templates of the default setup. They are turned on by name with
+// WithExtendedPatterns and applied among DefaultPatterns, see builtinOrder.
+var ExtendedPatterns = []Pattern{
+	{"uuid", regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<UUID>", 0},
+	{"email", regexp.MustCompile(`\b[\w.+-]+@[\w-]+(\.[\w-]+)+\b`), "<EMAIL>", 0},
+	{"windows-path", regexp.MustCompile(`(\b[A-Za-z]:|\\\\[\w.$-]+)\\[^\s"'<>|*?:]*`), "<PATH>", 0},
+	{"ipv4", regexp.MustCompile(`/?\b((25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`), "<IP>", 0},
+	{"unix-path", regexp.MustCompile(`\B(/[\w.-]+){2,}/?`), "<PATH>", 0},
+	{"size", regexp.MustCompile(`\b\d+(\.\d+)?([KMGTPE]i?B|kB|B)\b`), "<SIZE>", 0},
+	{"duration", regexp.MustCompile(`\b(\d+(\.\d+)?(ns|us|µs|ms|s|m|h))+\b`), "<DURATION>", 0},
+}
+
+// builtinOrder is the order in which built-in patterns are applied, with the
+// extended ones placed so that e.g. a Windows path is replaced before the
+// date in it and an IPv4 address before the host it looks like. Patterns not
+// listed, such as ones added to DefaultPatterns, are applied last.
+var builtinOrder = []string{
+	"mac", "uuid", "email", "windows-path", "date", "date-slash", "time", "hex",
+	"ipv6", "url", "ipv4", "unix-path", "host", "size", "duration",
+}
+
+// rawPatterns are the built-in patterns whose matches contain characters
+// removed as punctuation. Their matches are kept intact when punctuation is
+// removed, so that they are still found afterwards.
+var rawPatterns = map[string]bool{"email": true, "windows-path": true}
+
+// withPattern adds a named pattern with the given priority, see Pattern.
+func withPattern(name, pattern, placeholder string, priority int) Option {
+	return func(p *Parser) error {
+		pat, err := compilePattern(name, pattern, placeholder)
+		if err != nil {
+			return err
+		}
+		pat.Priority = priority
+		p.patterns = append(p.patterns, pat)
+		return nil
+	}
+}
+
+// compilePattern validates and compiles a named pattern.
+func compilePattern(name, pattern, placeholder string) (Pattern, error) {
+	if name == "" {
+		return Pattern{}, fmt.Errorf("pattern name cannot be empty")
+	}
+	if isBuiltinPattern(name) {
+		return Pattern{}, fmt.Errorf("pattern %s: name is taken by a built-in pattern; disable it and use another name", name)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("pattern %s: invalid regex %q: %w", name, pattern, err)
+	}
+	if err := validatePlaceholder(placeholder); err != nil {
+		return Pattern{}, fmt.Errorf("pattern %s: %w", name, err)
+	}
+	return Pattern{Name: name, Regex: re, Placeholder: placeholder}, nil
+}
+
+// isBuiltinPattern reports whether name is that of a default or extended
+// built-in pattern.
+func isBuiltinPattern(name string) bool {
+	for _, pat := range slices.Concat(DefaultPatterns, ExtendedPatterns) {
+		if pat.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePlaceholder checks that a placeholder is a single "<NAME>" token.
+func validatePlaceholder(placeholder string) error {
+	if placeholder == "" {
+		return nil
+	}
+	if len(placeholder) < 3 || placeholder[0] != '<' || placeholder[len(placeholder)-1] != '>' ||
+		strings.ContainsAny(placeholder[1:len(placeholder)-1], " \t<>") {
+		return fmt.Errorf("invalid placeholder %q, want a single token like <NAME>", placeholder)
+	}
+	return nil
+}
+
+// initPatterns sets the patterns applied by preprocess and the lookup of
+// typed placeholders by token, used to recognize them in templates. It is
+// called once all options are set, and fails if an extended or disabled
+// pattern doesn't exist.
+func (p *Parser) initPatterns() error {
+	builtins, err := p.builtinPatterns()
+	if err != nil {
+		return err
+	}
+	all := make([]Pattern, 0, len(builtins)+len(p.customRegex)+len(p.patterns))
+	all = append(all, builtins...)
+	for i, re := range p.customRegex {
+		all = append(all, Pattern{Name: "custom-" + intToStr(i+1), Regex: re})
+	}
+	all = append(all, p.patterns...)
+	slices.SortStableFunc(all, func(a, b Pattern) int { return b.Priority - a.Priority })
+
+	known := make(map[string]bool, len(all)+len(ExtendedPatterns))
+	for _, pat := range all {
+		known[pat.Name] = true
+	}
+	for _, pat := range ExtendedPatterns {
+		known[pat.Name] = true
+	}
+	disabled := make(map[string]bool, len(p.disabledPatterns))
+	for _, name := range p.disabledPatterns {
+		if !known[name] {
+			return fmt.Errorf("cannot disable unknown pattern %q", name)
+		}
+		disabled[name] = true
+	}
+	p.rules, p.rawRules = nil, nil
+	for _, pat := range all {
+		if disabled[pat.Name] {
+			continue
+		}
+		p.rules = append(p.rules, pat)
+		if rawPatterns[pat.Name] {
+			p.rawRules = append(p.rawRules, pat)
+		}
+	}
+
+	p.placeholders = nil
+	if !p.typedPlaceholders {
+		return nil
+	}
+	p.placeholders = make(map[string]string)
+	for _, pat := range p.rules {
+		if pat.Placeholder != "" {
+			p.placeholders[pat.Placeholder] = strings.Trim(pat.Placeholder, "<>")
+		}
+	}
+	return nil
+}
+
+// builtinPatterns returns DefaultPatterns and the turned on ExtendedPatterns
+// in builtinOrder.
+func (p *Parser) builtinPatterns() ([]Pattern, error) {
+	builtins := slices.Clone(DefaultPatterns)
+	for _, name := range p.extendedPatterns {
+		i := slices.IndexFunc(ExtendedPatterns, func(pat Pattern) bool { return pat.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown extended pattern %q", name)
+		}
+		if !slices.ContainsFunc(builtins, func(pat Pattern) bool { return pat.Name == name }) {
+			builtins = append(builtins, ExtendedPatterns[i])
+		}
+	}
+	rank := func(pat Pattern) int {
+		if i := slices.Index(builtinOrder, pat.Name); i >= 0 {
+			return i
+		}
+		return len(builtinOrder)
+	}
+	slices.SortStableFunc(builtins, func(a, b Pattern) int { return rank(a) - rank(b) })
+	return builtins, nil
+}
@@ -0,0 +1,379 @@
+package ulp
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const typedLog = `Request from 0xfa1 at 2024-01-15 to https://example.com/a done
+Request from 0x2b at 2024-01-16 to https://example.com/b done
+Request from 0x3c at 2024-01-17 to https://example.com/c done
+`
+
+func TestDefaultPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    string // the first match, "" for none
+	}{
+		{"mac", "aa:bb:cc:dd:ee:ff", "aa:bb:cc:dd:ee:ff"},
+		{"mac", "aa:bb:cc:dd:ee", ""},
+		{"uuid", "id=123e4567-e89b-12d3-a456-426614174000", "123e4567-e89b-12d3-a456-426614174000"},
+		{"uuid", "123e4567-e89b-12d3-a456-42661417400", ""},
+		{"uuid", "123e4567-e89b-12d3-a456-4266141740001", ""},
+		{"uuid", "g23e4567-e89b-12d3-a456-426614174000", ""},
+		{"url", "see https://example.com/a?b=c done", "https://example.com/a?b=c"},
+		{"url", "ftp://example.com", ""},
+		{"email", "from alice.b+tag@mail.example.com", "alice.b+tag@mail.example.com"},
+		{"email", "user@localhost", ""},
+		{"email", "@mention", ""},
+		{"windows-path", `open C:\Users\bob\file.txt`, `C:\Users\bob\file.txt`},
+		{"windows-path", `share \\server\logs\app.log`, `\\server\logs\app.log`},
+		{"windows-path", `warning:\n`, ""},
+		{"windows-path", "C:", ""},
+		{"date", "on 2024-01-15", "2024-01-15"},
+		{"date", "24-01-15", ""},
+		{"date-slash", "on 2024/01/15", "2024/01/15"},
+		{"date-slash", "3/4", ""},
+		{"time", "at 10:30:22.123", "10:30:22.123"},
+		{"time", "10:30", ""},
+		{"hex", "code 0xDEADBEEF", "0xDEADBEEF"},
+		{"hex", "DEADBEEF", ""},
+		{"ipv6", "fe80:0:0:0:202:b3ff:fe1e:8329:", "fe80:0:0:0:202:b3ff:fe1e:8329:"},
+		{"ipv6", "10:30:22", ""},
+		{"ipv4", "from 192.168.1.100", "192.168.1.100"},
+		{"ipv4", "src: /10.251.43.21:50010", "/10.251.43.21"},
+		{"ipv4", "version 1.2.3", ""},
+		{"ipv4", "256.1.1.1", ""},
+		{"ipv4", "a1.2.3.4", ""},
+		{"unix-path", "GET /api/users/123", "/api/users/123"},
+		{"unix-path", "read /var/log/app.log", "/var/log/app.log"},
+		{"unix-path", "100 requests/hour", ""},
+		{"unix-path", "GET /health", ""},
+		{"unix-path", "a/b/c", ""},
+		{"host", "node.example.com", "node.example.com"},
+		{"host", "document_abc123.pdf", ""},
+		{"size", "size 2.5MB", "2.5MB"},
+		{"size", "512KiB and 4kB", "512KiB"},
+		{"size", "100B", "100B"},
+		{"size", "2.5 MB", ""},
+		{"size", "MB", ""},
+		{"size", "3Bears", ""},
+		{"duration", "in 150ms", "150ms"},
+		{"duration", "after 30s", "30s"},
+		{"duration", "took 1h30m", "1h30m"},
+		{"duration", "waited 2.5s", "2.5s"},
+		{"duration", "3600 seconds", ""},
+		{"duration", "10min", ""},
+		{"duration", "user123s", ""},
+		{"duration", "2.5MB", ""},
+	}
+	patterns := make(map[string]Pattern)
+	for _, pat := range append(slices.Clone(DefaultPatterns), ExtendedPatterns...) {
+		patterns[pat.Name] = pat
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.input, func(t *testing.T) {
+			pat, ok := patterns[tt.pattern]
+			if !ok {
+				t.Fatalf("no built-in pattern %q", tt.pattern)
+			}
+			if got := pat.Regex.FindString(tt.input); got != tt.want {
+				t.Errorf("%s matches %q in %q, want %q", tt.pattern, got, tt.input, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternPrecedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"url before host and path", "GET https://api.example.com/v1/users", "GET <URL>"},
+		{"email before host", "mail to bob@mail.example.com", "mail to <EMAIL>"},
+		{"email keeps punctuation", "test@email#hash bob@example.com", "testemailhash <EMAIL>"},
+		{"windows path keeps backslashes", `open C:\Users\bob\2024-01-15.log`, "open <PATH>"},
+		{"ipv4 before host", "from 10.0.0.1 via gw.example.com", "from <IP> via <HOST>"},
+		{"ipv4 with slash and port", "src: /10.251.43.21:50010", "src: <IP>:50010"},
+		{"unix path before host", "read /var/log/app.example.com", "read <PATH>"},
+		{"date before path", "dir 2024/01/15", "dir <DATE>"},
+		{"date before url", "see https://example.com/2024-01-15", "see <URL>"},
+		{"size and duration", "sent 2.5MB in 150ms", "sent <SIZE> in <DURATION>"},
+		{"uuid", "job 123e4567-e89b-12d3-a456-426614174000 done", "job <UUID> done"},
+	}
+	p, err := New(WithTypedPlaceholders(true), WithExtendedPatterns())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.preprocess(tt.input); got != tt.want {
+				t.Errorf("preprocess(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultPatternsBaseline(t *testing.T) {
+	// The templates of the default setup, which extended patterns must not
+	// change unless turned on
+	tests := []struct {
+		file string
+		opts []Option
+		want []string
+	}{
+		{
+			"testdata/hdfs_sample.log",
+			[]Option{WithPreset("HDFS")},
+			[]string{
+				"PacketResponder <*> for block <*> terminating",
+				"Received block <*> of size 67108864 from <*>",
+				"BLOCK* NameSystem.addStoredBlock: blockMap updated: <*>:50010 is added to <*> size 67108864",
+			},
+		},
+		{
+			"testdata/sample.log",
+			[]Option{WithHeaderFormat("<Date> <Time> <Level> <Content>")},
+			[]string{
+				"User login successful: <*>",
+				"Database connection failed: timeout after <*>",
+				"Cache miss for key: <*>",
+				"HTTP request processed: GET <*> 200 OK",
+				"HTTP request processed: POST /api/orders/789 201 Created",
+				"Authentication failed for IP: <*>",
+				"File uploaded successfully: <*> size <*>",
+				"Query executed in 150ms: SELECT * FROM users WHERE id = 123",
+				"Query executed in 200ms: SELECT * FROM orders WHERE user_id = 456",
+				"Rate limit exceeded for user: <*> ( 100 requests/hour )",
+				"Session expired for user: user789 after 3600 seconds",
+			},
+		},
+		{
+			"testdata/syslog_rfc3164.log",
+			[]Option{WithPreset("syslog-rfc3164")},
+			[]string{
+				"Accepted publickey for deploy from <*> port 52144 ssh2",
+				"pam_unix ( sshd:session ) : session opened for user deploy by ( uid = 0 )",
+				"Started Session <*> of user <*>",
+				"( root ) CMD ( command -v debian-sa1 /dev/null debian-sa1 1 1 )",
+				"[ 12345.678901 ] eth0: Link is Up - 1Gbps/Full",
+				"Accepted publickey for admin from <*> port 40022 ssh2",
+				"deploy : TTY = pts/0 ; PWD = /home/deploy ; USER = root ; COMMAND = /bin/systemctl restart nginx",
+				"-- MARK --",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			result := parseFile(t, tt.file, tt.opts...)
+			if got := templateStrings(result.Templates); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("templates = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithExtendedPatterns(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, "123e4567-e89b-12d3-a456-426614174000 from <*> took 150ms"},
+		{"some", []Option{WithExtendedPatterns("uuid", "duration")}, "<*> from <*> took <*>"},
+		{"all", []Option{WithExtendedPatterns()}, "<*> from <*> took <*>"},
+		{"disabled", []Option{WithExtendedPatterns(), WithDisabledPatterns("duration")}, "<*> from <*> took 150ms"},
+	}
+	line := "123e4567-e89b-12d3-a456-426614174000 from 10.0.0.1 took 150ms"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if got := p.preprocess(line); got != tt.want {
+				t.Errorf("preprocess(%q) = %q, want %q", line, got, tt.want)
+			}
+		})
+	}
+
+	// Extended patterns are applied among the default ones in builtinOrder
+	p, err := New(WithExtendedPatterns("duration", "ipv4"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var names []string
+	for _, pat := range p.rules {
+		names = append(names, pat.Name)
+	}
+	want := []string{"mac", "date", "date-slash", "time", "hex", "ipv6", "url", "ipv4", "host", "duration"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("pattern order = %v, want %v", names, want)
+	}
+
+	for _, names := range [][]string{{"no-such-pattern"}, {""}, {"host"}} {
+		if _, err := New(WithExtendedPatterns(names...)); err == nil {
+			t.Errorf("WithExtendedPatterns(%q) error = nil", names)
+		}
+	}
+}
+
+func TestWithDisabledPatterns(t *testing.T) {
+	p, err := New(WithExtendedPatterns(), WithDisabledPatterns("duration", "size"), WithCustomRegex([]string{`user\d+`}), WithDisabledPatterns("custom-1"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got, want := p.preprocess("user1 sent 2.5MB in 150ms to 10.0.0.1"), "user1 sent 2.5MB in 150ms to <*>"; got != want {
+		t.Errorf("preprocess() = %q, want %q", got, want)
+	}
+
+	// Without the email pattern, its punctuation is removed as usual
+	p, err = New(WithExtendedPatterns("email"), WithDisabledPatterns("email"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got, want := p.preprocess("bob@example.com"), "bobexample.com"; got != want {
+		t.Errorf("preprocess() = %q, want %q", got, want)
+	}
+
+	for _, names := range [][]string{{"no-such-pattern"}, {""}, {"custom-1"}} {
+		if _, err := New(WithDisabledPatterns(names...)); err == nil {
+			t.Errorf("WithDisabledPatterns(%q) error = nil", names)
+		}
+	}
+}
+
+func TestTypedPlaceholders(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		wantTemplate string
+		wantTypes    []string
+	}{
+		{"default", nil, "Request from <*> at <*> to <*> done", []string{"", "", ""}},
+		{"typed", []Option{WithTypedPlaceholders(true)}, "Request from <HEX> at <DATE> to <URL> done", []string{"HEX", "DATE", "URL"}},
+		{
+			"custom pattern",
+			[]Option{WithTypedPlaceholders(true), WithPattern("request", `Request`, "<REQ>")},
+			"<REQ> from <HEX> at <DATE> to <URL> done",
+			[]string{"REQ", "HEX", "DATE", "URL"},
+		},
+		{
+			"custom pattern without placeholder",
+			[]Option{WithTypedPlaceholders(true), WithPattern("request", `Request`, "")},
+			"<*> from <HEX> at <DATE> to <URL> done",
+			[]string{"", "HEX", "DATE", "URL"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			result, err := p.Parse(strings.NewReader(typedLog))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(result.Templates) != 1 || result.Templates[0].Template != tt.wantTemplate {
+				t.Fatalf("templates = %v, want [%s]", templateStrings(result.Templates), tt.wantTemplate)
+			}
+			for _, ev := range result.Events {
+				var types []string
+				for _, param := range ev.Parameters {
+					types = append(types, param.Type)
+				}
+				if !reflect.DeepEqual(types, tt.wantTypes) {
+					t.Errorf("line %d: parameter types = %q, want %q", ev.LineID, types, tt.wantTypes)
+				}
+			}
+		})
+	}
+}
+
+func TestTypedPlaceholdersMatcher(t *testing.T) {
+	p, err := New(WithTypedPlaceholders(true))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader(typedLog))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	m := NewMatcher(p, result.Templates)
+	tmpl, _, ok := m.Match("Request from 0xff at 2025-02-01 to http://b.org/ done")
+	if !ok || tmpl.Template != "Request from <HEX> at <DATE> to <URL> done" {
+		t.Fatalf("Match() = %v, %v", tmpl, ok)
+	}
+	_, spans, _ := m.matchTokens(strings.Fields(p.preprocess("Request from 0xff at 2025-02-01 to http://b.org/ done")))
+	var types []string
+	for _, sp := range spans {
+		types = append(types, sp.typ)
+	}
+	if want := []string{"HEX", "DATE", "URL"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("parameter types = %q, want %q", types, want)
+	}
+}
+
+func TestWithPatternErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		placeholder string
+		wantErr     string
+	}{
+		{"", `x`, "", "name cannot be empty"},
+		{"bad", `(`, "", "invalid regex"},
+		{"bad", `x`, "IP", "invalid placeholder"},
+		{"bad", `x`, "<A B>", "invalid placeholder"},
+		{"bad", `x`, "<>", "invalid placeholder"},
+		{"host", `x`, "", "pattern host: name is taken by a built-in pattern"},
+		{"ipv4", `x`, "", "pattern ipv4: name is taken by a built-in pattern"},
+	}
+	for _, tt := range tests {
+		_, err := New(WithPattern(tt.name, tt.pattern, tt.placeholder))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("WithPattern(%q, %q, %q) error = %v, want %q", tt.name, tt.pattern, tt.placeholder, err, tt.wantErr)
+		}
+	}
+}
+
+func TestModelRoundTripPatterns(t *testing.T) {
+	p, err := New(WithTypedPlaceholders(true), WithPattern("session", `sess-\d+`, "<SESSION>"),
+		WithExtendedPatterns("duration"), WithDisabledPatterns("date"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.SaveModel(&buf, nil); err != nil {
+		t.Fatalf("SaveModel() error = %v", err)
+	}
+	loaded, _, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+	if !loaded.typedPlaceholders || len(loaded.patterns) != 1 || loaded.patterns[0].Name != "session" ||
+		loaded.patterns[0].Placeholder != "<SESSION>" || !reflect.DeepEqual(loaded.disabledPatterns, []string{"date"}) ||
+		!reflect.DeepEqual(loaded.extendedPatterns, []string{"duration"}) {
+		t.Errorf("patterns not restored: typed=%v patterns=%+v extended=%v disabled=%v",
+			loaded.typedPlaceholders, loaded.patterns, loaded.extendedPatterns, loaded.disabledPatterns)
+	}
+	line := "Opened sess-42 at 2024-01-15 in 30s"
+	if got, want := loaded.preprocess(line), p.preprocess(line); got != want {
+		t.Errorf("loaded parser preprocess = %q, want %q", got, want)
+	}
+}
+
+// templateStrings returns the template strings of templates.
+func templateStrings(templates []*LogTemplate) []string {
+	out := make([]string, len(templates))
+	for i, tmpl := range templates {
+		out[i] = tmpl.Template
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+package ulp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+)
+
+// maxLineBytes is the longest line, or record of joined partial lines,
+// that is read.
+const maxLineBytes = 1024 * 1024
+
+// preprocessBatchSize is the number of lines handed to a preprocessing
+// worker at once. Batching keeps channel overhead low relative to the
+// regex work done per line.
+const preprocessBatchSize = 256
+
+// lineBatch is a run of consecutive records flowing through the
+// preprocessing pipeline.
+type lineBatch struct {
+	seq     int         // batch sequence number, used to restore order
+	records []rawRecord // raw records
+	bytes   int64       // input bytes consumed once the batch was read
+	events  []*LogEvent
+}
+
+// scanOptions controls the side effects of scanEvents. The zero value
+// scans silently.
+type scanOptions struct {
+	progress *progressTracker
+	stats    *ParseStats // counters to update, if non-nil
+	rejects  io.Writer   // receives records whose header didn't match, if non-nil
+	fields   fieldSet    // collects header field names of structured input, if non-nil
+	workers  int         // preprocessing workers, 0 means maxWorkers
+}
+
+// scanOptions returns options reporting progress and header mismatches as
+// configured on the parser, counting into stats.
+func (p *Parser) scanOptions(stats *ParseStats) scanOptions {
+	opts := scanOptions{
+		progress: p.newProgressTracker(),
+		stats:    stats,
+		rejects:  p.rejects,
+	}
+	if p.input != nil {
+		opts.fields = make(fieldSet)
+	}
+	return opts
+}
+
+// readResult is the outcome of the reader stage.
+type readResult struct {
+	bytes int64
+	err   error
+}
+
+// scanEvents reads log lines from r and passes each preprocessed LogEvent
+// to fn in line order. Lines are read on one goroutine, preprocessed in
+// batches by up to maxWorkers goroutines and handed to fn in their original
+// order on the calling goroutine, which also assigns LineIDs to the events
+// not dropped by the header mismatch policy. Scanning stops at the first
+// error returned by fn or when ctx is done. Progress is reported every
+// progressLineInterval records and once reading is complete.
+func (p *Parser) scanEvents(ctx context.Context, r io.Reader, opts scanOptions, fn func(*LogEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := max(1, p.maxWorkers)
+	if opts.workers > 0 {
+		workers = opts.workers
+	}
+	jobs := make(chan *lineBatch, workers)
+	results := make(chan *lineBatch, workers)
+	// Bounds the number of batches in flight so that a slow batch can't
+	// make the collector buffer an unbounded number of later ones
+	inflight := make(chan struct{}, 2*workers)
+	readDone := make(chan readResult, 1)
+
+	// Reader stage
+	go func() {
+		defer close(jobs)
+		readDone <- p.readBatches(ctx, r, jobs, inflight)
+	}()
+
+	// Preprocessing stage
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Go(func() {
+			for b := range jobs {
+				b.events = make([]*LogEvent, len(b.records))
+				for j, rec := range b.records {
+					b.events[j] = p.newEvent(rec)
+				}
+				select {
+				case results <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collector stage: restore line order and emit events
+	var fnErr error
+	pending := make(map[int]*lineBatch)
+	next := 0
+	records := 0
+	lineID := 0
+	for b := range results {
+		if fnErr != nil {
+			continue // drain so that workers can exit
+		}
+		pending[b.seq] = b
+		for fnErr == nil {
+			nb, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			for j, ev := range nb.events {
+				records++
+				skip := p.skipEvent(ev)
+				if fnErr = opts.record(nb.records[j], ev.HeaderMatched, skip); fnErr == nil && !skip {
+					lineID++
+					ev.LineID = lineID
+					if opts.fields != nil {
+						opts.fields.add(ev.Headers)
+					}
+					fnErr = fn(ev)
+				}
+				if fnErr != nil {
+					cancel()
+					break
+				}
+				if records%progressLineInterval == 0 {
+					opts.progress.read(records, nb.bytes)
+				}
+			}
+			<-inflight
+		}
+	}
+
+	rr := <-readDone
+	if fnErr != nil {
+		return fnErr
+	}
+	if rr.err != nil {
+		return rr.err
+	}
+	opts.progress.read(records, rr.bytes)
+	return ctx.Err()
+}
+
+// record updates stats for a scanned record and writes it to the rejects
+// output if its header didn't match.
+func (o scanOptions) record(rec rawRecord, matched, skipped bool) error {
+	if o.stats != nil {
+		o.stats.Lines++
+		if !matched {
+			o.stats.HeaderMismatches++
+		}
+		if skipped {
+			o.stats.Skipped++
+		}
+	}
+	if !matched && o.rejects != nil {
+		if _, err := io.WriteString(o.rejects, rec.text+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBatches scans r into batches of records and sends them to jobs,
+// waiting for a free inflight slot before each send. Empty lines are
+// skipped; in multi-line mode continuation lines are joined to their event,
+// as are the partial lines of container logs.
+func (p *Parser) readBatches(ctx context.Context, r io.Reader, jobs chan<- *lineBatch, inflight chan struct{}) readResult {
+	counter := &countingReader{r: r}
+	scanner := bufio.NewScanner(counter)
+	// Allow long lines (up to 1MB)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	// Partial lines of container logs are joined before anything else;
+	// multi-line mode doesn't apply to them
+	var partials *partialJoiner
+	var joiner *multilineJoiner
+	if in, ok := p.input.(partialInput); ok {
+		partials = &partialJoiner{in: in}
+	} else if p.multiline {
+		joiner = &multilineJoiner{p: p}
+	}
+
+	lineNo := 0
+	seq := 0
+	batch := &lineBatch{}
+	send := func() error {
+		batch.seq = seq
+		batch.bytes = counter.n
+		select {
+		case inflight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case jobs <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		seq++
+		batch = &lineBatch{}
+		return nil
+	}
+	push := func(rec rawRecord) error {
+		batch.records = append(batch.records, rec)
+		if len(batch.records) < preprocessBatchSize {
+			return nil
+		}
+		return send()
+	}
+
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return readResult{err: err}
+		}
+		lineNo++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		rec := rawRecord{text: line, startLine: lineNo, endLine: lineNo}
+		if partials != nil {
+			var ok bool
+			if rec, ok = partials.add(line, lineNo); !ok {
+				continue
+			}
+		}
+		if joiner != nil {
+			var ok bool
+			if rec, ok = joiner.add(line, lineNo); !ok {
+				continue
+			}
+		}
+		if err := push(rec); err != nil {
+			return readResult{err: err}
+		}
+	}
+
+	// Records read before a scanner error are still emitted
+	if partials != nil {
+		for _, rec := range partials.flush() {
+			if err := push(rec); err != nil {
+				return readResult{err: err}
+			}
+		}
+	}
+	if joiner != nil {
+		if rec, ok := joiner.flush(); ok {
+			if err := push(rec); err != nil {
+				return readResult{err: err}
+			}
+		}
+	}
+	if len(batch.records) > 0 {
+		if err := send(); err != nil {
+			return readResult{err: err}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return readResult{err: err}
+	}
+	return readResult{bytes: counter.n}
+}
@@ -0,0 +1,135 @@
+package ulp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestScanEventsPreservesOrder(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 5*preprocessBatchSize+17; i++ {
+		fmt.Fprintf(&b, "request %d served\n", i)
+		if i%100 == 0 {
+			b.WriteString("\n") // empty lines don't consume LineIDs
+		}
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			p, _ := New(WithMaxWorkers(workers))
+			want := 1
+			err := p.scanEvents(context.Background(), strings.NewReader(b.String()), scanOptions{}, func(ev *LogEvent) error {
+				if ev.LineID != want {
+					return fmt.Errorf("got LineID %d, want %d", ev.LineID, want)
+				}
+				if ev.RawContent != fmt.Sprintf("request %d served", want) {
+					return fmt.Errorf("line %d has content %q", want, ev.RawContent)
+				}
+				want++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("scanEvents() error = %v", err)
+			}
+			if want-1 != 5*preprocessBatchSize+17 {
+				t.Errorf("scanned %d events, want %d", want-1, 5*preprocessBatchSize+17)
+			}
+		})
+	}
+}
+
+func TestScanEventsCallbackError(t *testing.T) {
+	input := strings.Repeat("msg 1\n", 10*preprocessBatchSize)
+	stop := errors.New("stop")
+
+	p, _ := New(WithMaxWorkers(4))
+	n := 0
+	err := p.scanEvents(context.Background(), strings.NewReader(input), scanOptions{}, func(*LogEvent) error {
+		n++
+		if n == 300 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("scanEvents() error = %v, want %v", err, stop)
+	}
+	if n != 300 {
+		t.Errorf("callback called %d times after error, want 300", n)
+	}
+}
+
+// replicatedTestdata concatenates the testdata files until the result has
+// at least the given number of lines.
+func replicatedTestdata(tb testing.TB, lines int) string {
+	tb.Helper()
+	var chunk strings.Builder
+	for _, name := range []string{"testdata/hdfs_sample.log", "testdata/sample.log"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			tb.Fatalf("failed to read test data: %v", err)
+		}
+		chunk.Write(data)
+	}
+	n := strings.Count(chunk.String(), "\n")
+	return strings.Repeat(chunk.String(), lines/n+1)
+}
+
+// benchmarkWorkers are the worker counts the pipeline benchmarks compare.
+var benchmarkWorkers = []struct {
+	name    string
+	workers int
+}{
+	{"workers=1", 1},
+	{"workers=2", 2},
+	{"workers=4", 4},
+	{"workers=GOMAXPROCS", runtime.GOMAXPROCS(0)},
+}
+
+func BenchmarkScanEvents(b *testing.B) {
+	input := replicatedTestdata(b, 20000)
+
+	for _, bm := range benchmarkWorkers {
+		b.Run(bm.name, func(b *testing.B) {
+			p, err := New(WithMaxWorkers(bm.workers))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := p.scanEvents(context.Background(), strings.NewReader(input), scanOptions{}, func(*LogEvent) error {
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	input := replicatedTestdata(b, 20000)
+
+	for _, bm := range benchmarkWorkers {
+		b.Run(bm.name, func(b *testing.B) {
+			p, err := New(WithMaxWorkers(bm.workers))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Parse(strings.NewReader(input)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -3,30 +3,23 @@ package ulp
 import (
 	"fmt"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// defaultRegexPatterns are built-in patterns for obvious dynamic tokens,
-// ordered from most specific to most general.
-var defaultRegexPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`([\da-fA-F]{2}:){5}[\da-fA-F]{2}`),            // MAC address
-	regexp.MustCompile(`\d{4}-\d{2}-\d{2}`),                           // Date YYYY-MM-DD
-	regexp.MustCompile(`\d{4}/\d{2}/\d{2}`),                           // Date YYYY/MM/DD
-	regexp.MustCompile(`[0-9]{2}:[0-9]{2}:[0-9]{2}(?:[.,][0-9]{3})?`), // Time HH:MM:SS[.mmm]
-	regexp.MustCompile(`0[xX][0-9a-fA-F]+`),                           // Hex value
-	regexp.MustCompile(`([0-9a-fA-F]*:){8,}`),                         // IPv6-like
-	regexp.MustCompile(`https?://\S+`),                                // HTTP/HTTPS URL
-	regexp.MustCompile(`(/?)([a-zA-Z0-9-]+\.){2,}([a-zA-Z0-9-]+)?`),   // Domain/host
-}
-
-// punctuationToRemove are characters stripped during preprocessing.
-var punctuationToRemove = "!@#$%^&{}<>?\\|`~"
+// Default characters stripped during preprocessing, see WithPunctuation.
+const defaultPunctuation = "!@#$%^&{}<>?\\|`~"
 
-// bracketNormRe matches brackets and equals signs that need surrounding spaces.
-var bracketNormRe = regexp.MustCompile(`([=\(\)\[\]])`)
+// Default brackets and equals signs that get surrounding spaces during
+// preprocessing, see WithBrackets.
+const defaultBrackets = "=()[]"
 
 // parseHeaderFormat parses a header format string like "<Date> <Time> <Level> <Content>"
-// into a structured HeaderFormat.
+// into a structured HeaderFormat. The content field, if present, must be the
+// last field.
 func parseHeaderFormat(format, contentField string) (*HeaderFormat, error) {
 	if format == "" {
 		return nil, fmt.Errorf("header format cannot be empty")
@@ -74,22 +67,136 @@ func parseHeaderFormat(format, contentField string) (*HeaderFormat, error) {
 	if len(hf.fields) == 0 {
 		return nil, fmt.Errorf("no fields found in header format: %s", format)
 	}
+	// Fields are split off the line up to the content, which takes the rest
+	for _, field := range hf.fields[:len(hf.fields)-1] {
+		if field.name == contentField {
+			return nil, fmt.Errorf("content field <%s> must be the last field of the header format, use a header regex instead: %s", contentField, format)
+		}
+	}
 
 	return hf, nil
 }
 
+// parseHeaderRegex compiles a header regular expression with named groups,
+// one of which must be the content field.
+func parseHeaderRegex(pattern, contentField string) (*HeaderFormat, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("header regex cannot be empty")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header regex %q: %w", pattern, err)
+	}
+	if re.SubexpIndex(contentField) == -1 {
+		return nil, fmt.Errorf("header regex has no (?P<%s>...) group: %s", contentField, pattern)
+	}
+	return &HeaderFormat{
+		Format:       pattern,
+		ContentField: contentField,
+		regex:        re,
+	}, nil
+}
+
+// HeaderFormatRegex converts a "<Date> <Time> <Level> <Content>" style
+// format into an anchored regular expression, the way Loghub does: each
+// <Field> becomes a lazy named group (?P<Field>.*?), runs of spaces match
+// any amount of whitespace and other literal text is matched verbatim.
+// Unlike separator-based parsing, the whole line must fit the format.
+func HeaderFormatRegex(format string) (string, error) {
+	return formatRegex(format, true)
+}
+
+// formatRegex implements HeaderFormatRegex. If quote is false, literal text
+// other than spaces is copied as regex syntax, as in Loghub's benchmark
+// formats such as `<Component>(\[<PID>\])?: <Content>`.
+func formatRegex(format string, quote bool) (string, error) {
+	if format == "" {
+		return "", fmt.Errorf("header format cannot be empty")
+	}
+
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	remaining := format
+	fields := 0
+	for remaining != "" {
+		start := strings.Index(remaining, "<")
+		if start == -1 {
+			writeLiteralRegex(&b, remaining, quote)
+			break
+		}
+		end := strings.Index(remaining[start+1:], ">")
+		if end == -1 {
+			return "", fmt.Errorf("unclosed field marker in format: %s", format)
+		}
+		end += start + 1
+
+		fieldName := remaining[start+1 : end]
+		if strings.Contains(fieldName, "<") {
+			return "", fmt.Errorf("unclosed field marker in format: %s", format)
+		}
+		writeLiteralRegex(&b, remaining[:start], quote)
+		b.WriteString(`(?P<` + fieldName + `>.*?)`)
+		fields++
+		remaining = remaining[end+1:]
+	}
+	b.WriteString(`$`)
+
+	if fields == 0 {
+		return "", fmt.Errorf("no fields found in header format: %s", format)
+	}
+	return b.String(), nil
+}
+
+// writeLiteralRegex writes literal format text as a regex, matching runs
+// of spaces with \s+ and quoting other characters if quote is set.
+func writeLiteralRegex(b *strings.Builder, literal string, quote bool) {
+	space := false
+	for _, r := range literal {
+		if r == ' ' {
+			if !space {
+				b.WriteString(`\s+`)
+			}
+			space = true
+			continue
+		}
+		space = false
+		if quote {
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+}
+
 // extractContent parses a log line using the header format and returns
 // the content field value. If no header format is set, returns the whole line.
 func (p *Parser) extractContent(line string) string {
+	content, _, _ := p.parseHeader(line)
+	return content
+}
+
+// parseHeader parses a log line using the header format and returns the
+// content field value along with the other header fields that preceded it.
+// ok is false if a separator wasn't found, in which case the content is the
+// unparsed remainder of the line, or the whole line with HeaderMismatchWholeLine.
+// If no header format is set, returns the whole line and nil headers.
+func (p *Parser) parseHeader(line string) (content string, headers map[string]string, ok bool) {
+	if p.input != nil {
+		return p.input.decode(line)
+	}
 	if p.headerFormat == nil {
-		return line
+		return line, nil, true
+	}
+	if p.headerFormat.regex != nil {
+		return p.parseHeaderRegexMatch(line)
 	}
 
+	headers = make(map[string]string, len(p.headerFormat.fields)-1)
 	remaining := line
 	for i, field := range p.headerFormat.fields {
-		if field.name == p.contentField {
+		if field.name == p.headerFormat.ContentField {
 			// This is the content field — return everything remaining
-			return strings.TrimSpace(remaining)
+			return strings.TrimSpace(remaining), headers, true
 		}
 
 		// For the last field (or if no separator), consume the rest
@@ -101,73 +208,436 @@ func (p *Parser) extractContent(line string) string {
 		sepIdx := strings.Index(remaining, field.separator)
 		if sepIdx == -1 {
 			// Separator not found; fall back to returning everything
-			return strings.TrimSpace(remaining)
+			if p.headerMismatch == HeaderMismatchWholeLine {
+				return strings.TrimSpace(line), nil, false
+			}
+			return strings.TrimSpace(remaining), headers, false
 		}
+		headers[field.name] = strings.TrimSpace(remaining[:sepIdx])
 		remaining = remaining[sepIdx+len(field.separator):]
 	}
 
-	return strings.TrimSpace(remaining)
+	return strings.TrimSpace(remaining), headers, true
+}
+
+// parseHeaderRegexMatch is parseHeader for regex-based header formats.
+// A line that doesn't match is returned whole as content with ok false.
+func (p *Parser) parseHeaderRegexMatch(line string) (content string, headers map[string]string, ok bool) {
+	re := p.headerFormat.regex
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		return strings.TrimSpace(line), nil, false
+	}
+	headers = make(map[string]string, len(m)-1)
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		if name == p.headerFormat.ContentField {
+			content = m[i]
+		} else {
+			headers[name] = strings.TrimSpace(m[i])
+		}
+	}
+	return strings.TrimSpace(content), headers, true
+}
+
+// headerNames returns the names of the header fields in format order,
+// excluding the content field.
+func (p *Parser) headerNames() []string {
+	if p.headerFormat == nil {
+		return nil
+	}
+	if re := p.headerFormat.regex; re != nil {
+		var names []string
+		for i, name := range re.SubexpNames() {
+			if i > 0 && name != "" && name != p.headerFormat.ContentField {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	names := make([]string, 0, len(p.headerFormat.fields))
+	for _, field := range p.headerFormat.fields {
+		if field.name != p.headerFormat.ContentField {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
+
+// Token is a token of preprocessed content. Start and End are the byte
+// offsets of the text it was made from, End exclusive, so that values can be
+// traced back to the original content. They are equal if the token has no
+// counterpart in the text.
+type Token struct {
+	Text       string
+	Start, End int
+}
+
+// Preprocessor turns the content of a log event into the tokens the event
+// is grouped and templated by, with their offsets in content. Tokens are
+// kept joined by spaces in LogEvent.TokenString, so tokens containing
+// whitespace are split there, and empty ones dropped, see splitTokens.
+type Preprocessor interface {
+	Preprocess(content string) []Token
+}
+
+// PreprocessorFunc adapts a function to the Preprocessor interface.
+type PreprocessorFunc func(content string) []Token
+
+// Preprocess calls f(content).
+func (f PreprocessorFunc) Preprocess(content string) []Token { return f(content) }
+
+// Tokenizer splits content that has been normalized by the default
+// preprocessor into tokens, with their offsets in s.
+type Tokenizer interface {
+	Tokenize(s string) []Token
+}
+
+// TokenizerFunc adapts a function to the Tokenizer interface.
+type TokenizerFunc func(s string) []Token
+
+// Tokenize calls f(s).
+func (f TokenizerFunc) Tokenize(s string) []Token { return f(s) }
+
+// SplitTokenizer returns a Tokenizer that splits at runs of runes for which
+// isSep is true, like strings.FieldsFunc.
+func SplitTokenizer(isSep func(rune) bool) Tokenizer {
+	return TokenizerFunc(func(s string) []Token {
+		var tokens []Token
+		start := -1
+		for i, r := range s {
+			switch {
+			case isSep(r) && start >= 0:
+				tokens = append(tokens, Token{Text: s[start:i], Start: start, End: i})
+				start = -1
+			case !isSep(r) && start < 0:
+				start = i
+			}
+		}
+		if start >= 0 {
+			tokens = append(tokens, Token{Text: s[start:], Start: start, End: len(s)})
+		}
+		return tokens
+	})
 }
 
-// preprocess applies all preprocessing steps to a raw content string:
+// WhitespaceTokenizer splits at runs of whitespace, including the line
+// breaks of multi-line events. It is the default tokenizer.
+var WhitespaceTokenizer = SplitTokenizer(unicode.IsSpace)
+
+// initPreprocessor sets the preprocessor applied to the content of events.
+// It is called once all options are set and the patterns are known.
+func (p *Parser) initPreprocessor() {
+	def := &defaultPreprocessor{
+		punctuation: p.punctuation,
+		brackets:    p.brackets,
+		rules:       p.rules,
+		rawRules:    p.rawRules,
+		tokenizer:   p.tokenizer,
+	}
+	for _, pat := range p.rules {
+		repl := p.dynamicWildcard
+		if p.typedPlaceholders && pat.Placeholder != "" {
+			repl = pat.Placeholder
+		}
+		def.replacements = append(def.replacements, repl)
+	}
+	p.preprocessor = def
+	for _, wrap := range p.preprocessorWraps {
+		p.preprocessor = wrap(p.preprocessor)
+	}
+}
+
+// preprocess returns the tokens of content joined by spaces.
+func (p *Parser) preprocess(content string) string {
+	return joinTokens(p.preprocessor.Preprocess(content))
+}
+
+// splitTokens splits tokens that contain whitespace at it and drops empty
+// ones, so that tokens are those of their joined text. The parts of a token
+// whose text is that of its range in content get their own offsets; those of
+// other tokens keep the token's range.
+func splitTokens(tokens []Token, content string) []Token {
+	if !slices.ContainsFunc(tokens, func(tok Token) bool {
+		return tok.Text == "" || strings.ContainsFunc(tok.Text, unicode.IsSpace)
+	}) {
+		return tokens
+	}
+	split := make([]Token, 0, len(tokens)+1)
+	for _, tok := range tokens {
+		exact := 0 <= tok.Start && tok.Start <= tok.End && tok.End <= len(content) && content[tok.Start:tok.End] == tok.Text
+		for _, part := range WhitespaceTokenizer.Tokenize(tok.Text) {
+			if exact {
+				part.Start += tok.Start
+				part.End += tok.Start
+			} else {
+				part.Start, part.End = tok.Start, tok.End
+			}
+			split = append(split, part)
+		}
+	}
+	return split
+}
+
+// joinTokens returns the texts of tokens joined by spaces.
+func joinTokens(tokens []Token) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.Text)
+	}
+	return b.String()
+}
+
+// defaultPreprocessor implements the preprocessing of the paper:
 // 1. Remove punctuation
 // 2. Replace obvious dynamic tokens via regex
 // 3. Normalize brackets
-func (p *Parser) preprocess(content string) string {
+// 4. Split into tokens
+//
+// Each step rewrites a mappedString, which keeps track of the edits made to
+// the content so that tokens can be mapped back to it.
+type defaultPreprocessor struct {
+	punctuation  string
+	brackets     string
+	rules        []Pattern
+	rawRules     []Pattern // rules whose matches keep their punctuation
+	replacements []string  // what matches of rules are replaced with
+	tokenizer    Tokenizer
+}
+
+func (d *defaultPreprocessor) Preprocess(content string) []Token {
 	// Step 1: Remove punctuation characters
-	s := removePunctuation(content)
+	m := d.removePunctuation(content)
 
 	// Step 2: Replace obvious dynamic tokens with wildcard
-	s = p.replaceByRegex(s)
+	for i, pat := range d.rules {
+		m = m.replace(pat.Regex, d.replacements[i])
+	}
 
 	// Step 3: Normalize brackets — add spaces around = ( ) [ ]
-	s = bracketNormRe.ReplaceAllString(s, " $1 ")
+	m = m.spaceOut(d.brackets)
 
-	// Collapse multiple spaces
-	s = collapseSpaces(s)
+	// Step 4: Split into tokens and map them back to the content
+	tokens := d.tokenizer.Tokenize(m.s)
+	for i, tok := range tokens {
+		tokens[i].Start, tokens[i].End = m.origin(tok.Start, tok.End)
+	}
+	return tokens
+}
 
-	return strings.TrimSpace(s)
+// mappedString is a string rewritten from some content together with the
+// edits made to it. Bytes outside edits are copied from the content; the
+// bytes of an edit were made from a span of the content as a whole.
+type mappedString struct {
+	s     string
+	edits []edit // sorted by position in s
 }
 
-// removePunctuation strips characters in punctuationToRemove from the string.
-func removePunctuation(s string) string {
-	var b strings.Builder
-	b.Grow(len(s))
-	for _, r := range s {
-		if !strings.ContainsRune(punctuationToRemove, r) {
-			b.WriteRune(r)
-		}
+// edit records that s[pos:end] was made from content[start:stop]. Either
+// may be empty: a removal has pos == end, an insertion start == stop.
+type edit struct {
+	pos, end    int
+	start, stop int
+}
+
+// change replaces old[a:b] with text when a mappedString is rewritten.
+type change struct {
+	a, b int
+	text string
+}
+
+// newMappedString returns content mapped to itself.
+func newMappedString(content string) mappedString {
+	return mappedString{s: content}
+}
+
+// span returns the span of the content byte i of s was made from.
+func (m mappedString) span(i int) (int, int) {
+	k := sort.Search(len(m.edits), func(k int) bool { return m.edits[k].pos > i }) - 1
+	if k < 0 {
+		return i, i + 1
 	}
-	return b.String()
+	e := m.edits[k]
+	if i < e.end {
+		return e.start, e.stop
+	}
+	off := e.stop + i - e.end
+	return off, off + 1
 }
 
-// replaceByRegex replaces tokens matching default and custom regex patterns
-// with the dynamic wildcard.
-func (p *Parser) replaceByRegex(s string) string {
-	for _, re := range defaultRegexPatterns {
-		s = re.ReplaceAllString(s, p.dynamicWildcard)
+// origin returns the span of the content bytes s[start:end] were made
+// from, or an empty span if start and end don't delimit bytes of s.
+func (m mappedString) origin(start, end int) (int, int) {
+	if start < 0 || end > len(m.s) || start >= end {
+		return 0, 0
 	}
-	for _, re := range p.customRegex {
-		s = re.ReplaceAllString(s, p.dynamicWildcard)
+	from, _ := m.span(start)
+	_, to := m.span(end - 1)
+	return from, to
+}
+
+// emptySpan returns the empty span of the content at byte i of s.
+func (m mappedString) emptySpan(i int) (int, int) {
+	switch {
+	case i < len(m.s):
+		start, _ := m.span(i)
+		return start, start
+	case i > 0:
+		_, end := m.span(i - 1)
+		return end, end
 	}
-	return s
+	return 0, 0
 }
 
-// collapseSpaces replaces runs of whitespace with a single space.
-func collapseSpaces(s string) string {
+// apply returns s rewritten by changes, which must be sorted and not
+// overlap. Earlier edits within a change are folded into it; those it cuts
+// through keep their parts outside of it.
+func (m mappedString) apply(changes []change) mappedString {
+	if len(changes) == 0 {
+		return m
+	}
 	var b strings.Builder
-	b.Grow(len(s))
-	prevSpace := false
-	for _, r := range s {
-		if r == ' ' || r == '\t' {
-			if !prevSpace {
-				b.WriteByte(' ')
-				prevSpace = true
+	b.Grow(len(m.s))
+	edits := make([]edit, 0, len(m.edits)+len(changes))
+	add := func(e edit, delta int) {
+		e.pos += delta
+		e.end += delta
+		edits = append(edits, e)
+	}
+
+	// The earlier edits not handled yet: the part of one cut by the
+	// previous change, if any, followed by rest
+	rest := m.edits
+	var cut *edit
+	peek := func() (edit, bool) {
+		if cut != nil {
+			return *cut, true
+		}
+		if len(rest) > 0 {
+			return rest[0], true
+		}
+		return edit{}, false
+	}
+	pop := func() {
+		if cut != nil {
+			cut = nil
+		} else {
+			rest = rest[1:]
+		}
+	}
+
+	pos, delta := 0, 0
+	for _, c := range changes {
+		b.WriteString(m.s[pos:c.a])
+		for e, ok := peek(); ok && e.end <= c.a; e, ok = peek() {
+			pop()
+			add(e, delta)
+		}
+		var after *edit
+		for e, ok := peek(); ok && e.pos < c.b; e, ok = peek() {
+			pop()
+			if e.pos < c.a {
+				before := e
+				before.end = c.a
+				add(before, delta)
+			}
+			if e.end > c.b {
+				e.pos = c.b
+				after = &e
 			}
+		}
+
+		e := edit{pos: c.a, end: c.a + len(c.text)}
+		if c.a < c.b {
+			e.start, _ = m.span(c.a)
+			_, e.stop = m.span(c.b - 1)
 		} else {
-			b.WriteRune(r)
-			prevSpace = false
+			e.start, e.stop = m.emptySpan(c.a)
+		}
+		add(e, delta)
+		b.WriteString(c.text)
+		pos = c.b
+		delta += len(c.text) - (c.b - c.a)
+		if after != nil {
+			cut = after
 		}
 	}
-	return b.String()
+	b.WriteString(m.s[pos:])
+	for e, ok := peek(); ok; e, ok = peek() {
+		pop()
+		add(e, delta)
+	}
+	return mappedString{s: b.String(), edits: edits}
+}
+
+// replace replaces the matches of re with repl, like ReplaceAllLiteralString.
+func (m mappedString) replace(re *regexp.Regexp, repl string) mappedString {
+	matches := re.FindAllStringIndex(m.s, -1)
+	changes := make([]change, len(matches))
+	for i, match := range matches {
+		changes[i] = change{match[0], match[1], repl}
+	}
+	return m.apply(changes)
+}
+
+// filter keeps the bytes of the runes of s for which keep returns true.
+func (m mappedString) filter(keep func(i int, r rune) bool) mappedString {
+	var changes []change
+	for i := 0; i < len(m.s); {
+		r, n := utf8.DecodeRuneInString(m.s[i:])
+		if !keep(i, r) {
+			if last := len(changes) - 1; last >= 0 && changes[last].b == i {
+				changes[last].b += n
+			} else {
+				changes = append(changes, change{i, i + n, ""})
+			}
+		}
+		i += n
+	}
+	return m.apply(changes)
+}
+
+// spaceOut surrounds the characters in chars with spaces.
+func (m mappedString) spaceOut(chars string) mappedString {
+	if chars == "" || !strings.ContainsAny(m.s, chars) {
+		return m
+	}
+	var changes []change
+	for i := 0; i < len(m.s); {
+		r, n := utf8.DecodeRuneInString(m.s[i:])
+		if strings.ContainsRune(chars, r) {
+			changes = append(changes, change{i, i, " "}, change{i + n, i + n, " "})
+		}
+		i += n
+	}
+	return m.apply(changes)
+}
+
+// removePunctuation strips punctuation from content, except within matches
+// of rawRules.
+func (d *defaultPreprocessor) removePunctuation(content string) mappedString {
+	m := newMappedString(content)
+	if d.punctuation == "" || !strings.ContainsAny(content, d.punctuation) {
+		return m
+	}
+	var keep [][]int
+	for _, pat := range d.rawRules {
+		keep = append(keep, pat.Regex.FindAllStringIndex(content, -1)...)
+	}
+	slices.SortFunc(keep, func(a, b []int) int { return a[0] - b[0] })
+
+	return m.filter(func(i int, r rune) bool {
+		for len(keep) > 0 && keep[0][1] <= i {
+			keep = keep[1:]
+		}
+		if len(keep) > 0 && keep[0][0] <= i {
+			return true
+		}
+		return !strings.ContainsRune(d.punctuation, r)
+	})
 }
@@ -1,6 +1,12 @@
 package ulp
 
-import "testing"
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
 
 func TestParseHeaderFormat(t *testing.T) {
 	tests := []struct {
@@ -34,6 +40,24 @@ func TestParseHeaderFormat(t *testing.T) {
 			contentField: "Content",
 			wantErr:      true,
 		},
+		{
+			name:         "fields after content",
+			format:       "<Time> <Content> [<Thread>]",
+			contentField: "Content",
+			wantErr:      true,
+		},
+		{
+			name:         "literal text after content",
+			format:       "<Time> <Content> end",
+			contentField: "Content",
+			wantFields:   2,
+		},
+		{
+			name:         "custom content field not last",
+			format:       "<Msg> <Level>",
+			contentField: "Msg",
+			wantErr:      true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -95,6 +119,156 @@ func TestExtractContent(t *testing.T) {
 	}
 }
 
+func TestParseHeader(t *testing.T) {
+	p, err := New(WithHeaderFormat("<Date> <Time> <Pid> <Level> <Component>: <Content>"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	content, headers, ok := p.parseHeader("081109 203615 148 INFO dfs.DataNode$PacketResponder: PacketResponder 0 for block blk_1 terminating")
+	if !ok {
+		t.Error("parseHeader() ok = false, want true")
+	}
+	if content != "PacketResponder 0 for block blk_1 terminating" {
+		t.Errorf("parseHeader() content = %q", content)
+	}
+	want := map[string]string{
+		"Date":      "081109",
+		"Time":      "203615",
+		"Pid":       "148",
+		"Level":     "INFO",
+		"Component": "dfs.DataNode$PacketResponder",
+	}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("parseHeader() headers = %v, want %v", headers, want)
+	}
+
+	wantNames := []string{"Date", "Time", "Pid", "Level", "Component"}
+	if got := p.headerNames(); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("headerNames() = %v, want %v", got, wantNames)
+	}
+}
+
+func TestParseHeaderMismatch(t *testing.T) {
+	p, _ := New(WithHeaderFormat("<Date> <Time> <Level>: <Content>"))
+	content, _, ok := p.parseHeader("\tat com.example.Main.run(Main.java:42)")
+	if ok {
+		t.Error("parseHeader() ok = true for a line without header")
+	}
+	// Falls back to the remainder after the last separator found
+	if content != "com.example.Main.run(Main.java:42)" {
+		t.Errorf("parseHeader() content = %q", content)
+	}
+}
+
+func TestParseHeaderNoFormat(t *testing.T) {
+	p, _ := New()
+	content, headers, _ := p.parseHeader("some raw log line")
+	if content != "some raw log line" {
+		t.Errorf("parseHeader() content = %q", content)
+	}
+	if headers != nil {
+		t.Errorf("parseHeader() headers = %v, want nil", headers)
+	}
+}
+
+func TestHeaderFormatRegex(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			format: "<Date> <Time> <Level> <Content>",
+			want:   `(?s)^(?P<Date>.*?)\s+(?P<Time>.*?)\s+(?P<Level>.*?)\s+(?P<Content>.*?)$`,
+		},
+		{
+			format: "[<Date> <Time>] [<Thread>] <Level>  <Content>",
+			want:   `(?s)^\[(?P<Date>.*?)\s+(?P<Time>.*?)\]\s+\[(?P<Thread>.*?)\]\s+(?P<Level>.*?)\s+(?P<Content>.*?)$`,
+		},
+		{format: "", wantErr: true},
+		{format: "no fields", wantErr: true},
+		{format: "<Date <Content>", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := HeaderFormatRegex(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HeaderFormatRegex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("HeaderFormatRegex() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHeaderRegex(t *testing.T) {
+	pattern, err := HeaderFormatRegex("[<Date> <Time>] [<Thread>] <Level> <Content>")
+	if err != nil {
+		t.Fatalf("HeaderFormatRegex() error = %v", err)
+	}
+	p, err := New(WithHeaderRegex(pattern))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	content, headers, ok := p.parseHeader("[2024-01-15 10:30:22,123] [worker-3] INFO  Task 42 finished")
+	if !ok {
+		t.Error("parseHeader() ok = false, want true")
+	}
+	if content != "Task 42 finished" {
+		t.Errorf("parseHeader() content = %q", content)
+	}
+	want := map[string]string{
+		"Date":   "2024-01-15",
+		"Time":   "10:30:22,123",
+		"Thread": "worker-3",
+		"Level":  "INFO",
+	}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("parseHeader() headers = %v, want %v", headers, want)
+	}
+	if got, want := p.headerNames(), []string{"Date", "Time", "Thread", "Level"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("headerNames() = %v, want %v", got, want)
+	}
+
+	content, headers, ok = p.parseHeader("  Task 43 finished without header ")
+	if ok || headers != nil {
+		t.Errorf("parseHeader() = %v, %v for a non-matching line", headers, ok)
+	}
+	if content != "Task 43 finished without header" {
+		t.Errorf("parseHeader() content = %q", content)
+	}
+}
+
+func TestWithHeaderRegexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"empty", []Option{WithHeaderRegex("")}},
+		{"invalid", []Option{WithHeaderRegex(`(?P<Content>`)}},
+		{"no content group", []Option{WithHeaderRegex(`^(?P<Level>\w+) (?P<Message>.*)$`)}},
+		{"content field set later", []Option{
+			WithHeaderRegex(`^(?P<Level>\w+) (?P<Message>.*)$`),
+			WithContentField("Message"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.opts...); err == nil {
+				t.Error("New() error = nil, want error")
+			}
+		})
+	}
+
+	if _, err := New(WithContentField("Message"), WithHeaderRegex(`^(?P<Level>\w+) (?P<Message>.*)$`)); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+}
+
 func TestRemovePunctuation(t *testing.T) {
 	tests := []struct {
 		input string
@@ -108,9 +282,10 @@ func TestRemovePunctuation(t *testing.T) {
 		{"$100%", "100"},
 	}
 
+	d := &defaultPreprocessor{punctuation: defaultPunctuation}
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			got := removePunctuation(tt.input)
+			got := d.removePunctuation(tt.input).s
 			if got != tt.want {
 				t.Errorf("removePunctuation(%q) = %q, want %q", tt.input, got, tt.want)
 			}
@@ -178,7 +353,7 @@ func TestPreprocess(t *testing.T) {
 	}
 }
 
-func TestCollapseSpaces(t *testing.T) {
+func TestWhitespaceTokenizer(t *testing.T) {
 	tests := []struct {
 		input string
 		want  string
@@ -187,12 +362,216 @@ func TestCollapseSpaces(t *testing.T) {
 		{"a   b   c", "a b c"},
 		{"no extra spaces", "no extra spaces"},
 		{"tab\there", "tab here"},
+		{"multi\nline\r\nevent", "multi line event"},
+		{"  trimmed  ", "trimmed"},
 	}
 
 	for _, tt := range tests {
-		got := collapseSpaces(tt.input)
+		got := joinTokens(WhitespaceTokenizer.Tokenize(tt.input))
 		if got != tt.want {
-			t.Errorf("collapseSpaces(%q) = %q, want %q", tt.input, got, tt.want)
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPreprocessCharacters(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		input string
+		want  string
+	}{
+		{"default", nil, "issue #42 at 50% key=v(x)", "issue 42 at 50 key = v ( x )"},
+		{"punctuation", []Option{WithPunctuation("!")}, "issue #42 at 50%! ok", "issue #42 at 50% ok"},
+		{"no punctuation", []Option{WithPunctuation("")}, "a{b}!", "a{b}!"},
+		{"brackets", []Option{WithBrackets(":")}, "key=v(x) a:b", "key=v(x) a : b"},
+		{"no brackets", []Option{WithBrackets("")}, "key=v(x)", "key=v(x)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if got := p.preprocess(tt.input); got != tt.want {
+				t.Errorf("preprocess(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	for _, opt := range []Option{WithPunctuation("! "), WithBrackets("(\t)"), WithTokenizer(nil), WithPreprocessor(nil)} {
+		if _, err := New(opt); err == nil {
+			t.Error("New() error = nil for an invalid preprocessing option")
+		}
+	}
+}
+
+func TestWithPreprocessor(t *testing.T) {
+	// Chain a step before the default preprocessing and one after it
+	lower := func(next Preprocessor) Preprocessor {
+		return PreprocessorFunc(func(content string) []Token {
+			return next.Preprocess(strings.ToLower(content))
+		})
+	}
+	dropLevel := func(next Preprocessor) Preprocessor {
+		return PreprocessorFunc(func(content string) []Token {
+			tokens := next.Preprocess(content)
+			if len(tokens) > 0 && tokens[0].Text == "info" {
+				tokens = tokens[1:]
+			}
+			return tokens
+		})
+	}
+	p, err := New(WithPreprocessor(lower), WithPreprocessor(dropLevel))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got, want := p.preprocess("INFO Block 0xFF Served"), "block <*> served"; got != want {
+		t.Errorf("preprocess() = %q, want %q", got, want)
+	}
+
+	// Replace the default preprocessing
+	p, err = New(WithPreprocessor(func(Preprocessor) Preprocessor {
+		return PreprocessorFunc(SplitTokenizer(func(r rune) bool { return r == '|' }).Tokenize)
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader("get|1|ok\nget|2|ok\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(result.Templates) != 1 || result.Templates[0].Template != "get <*> ok" {
+		t.Errorf("templates = %v, want [get <*> ok]", templateStrings(result.Templates))
+	}
+}
+
+func TestWithTokenizer(t *testing.T) {
+	commas := SplitTokenizer(func(r rune) bool { return r == ',' || r == ' ' })
+	p, err := New(WithTokenizer(commas))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got, want := p.preprocess("users a,b,c at 2024-01-15"), "users a b c at <*>"; got != want {
+		t.Errorf("preprocess() = %q, want %q", got, want)
+	}
+}
+
+func TestTokensWithWhitespace(t *testing.T) {
+	// Tokens containing a space are split, so that parameters cover the
+	// right tokens and offsets
+	commas := SplitTokenizer(func(r rune) bool { return r == ',' })
+	p, err := New(WithTokenizer(commas))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	result, err := p.Parse(strings.NewReader("get,1 2,ok,x\nget,3 4,ok,x\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(result.Templates) != 1 || result.Templates[0].Template != "get <*> ok x" {
+		t.Fatalf("templates = %v, want [get <*> ok x]", templateStrings(result.Templates))
+	}
+	ev := result.Events[0]
+	if ev.TokenString != "get 1 2 ok x" || len(ev.TokenOffsets) != 5 {
+		t.Errorf("tokens = %q with offsets %v", ev.TokenString, ev.TokenOffsets)
+	}
+	want := []Parameter{{Position: 1, Value: "1 2", Start: 4, End: 7}}
+	if !reflect.DeepEqual(ev.Parameters, want) {
+		t.Errorf("parameters = %+v, want %+v", ev.Parameters, want)
+	}
+}
+
+func TestSplitTokens(t *testing.T) {
+	content := "a b,c"
+	got := splitTokens([]Token{{Text: "a b", Start: 0, End: 3}, {Text: ""}, {Text: "x y"}, {Text: "c", Start: 4, End: 5}}, content)
+	want := []Token{
+		{Text: "a", Start: 0, End: 1},
+		{Text: "b", Start: 2, End: 3},
+		{Text: "x"},
+		{Text: "y"},
+		{Text: "c", Start: 4, End: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitTokens() = %+v, want %+v", got, want)
+	}
+}
+
+func TestModelRoundTripPreprocessing(t *testing.T) {
+	p, err := New(WithPunctuation(""), WithBrackets(":"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.SaveModel(&buf, nil); err != nil {
+		t.Fatalf("SaveModel() error = %v", err)
+	}
+	loaded, _, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+	line := "issue #42 key=v a:b"
+	if got, want := loaded.preprocess(line), p.preprocess(line); got != want {
+		t.Errorf("loaded parser preprocess = %q, want %q", got, want)
+	}
+}
+
+func TestPreprocessOffsets(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string // content[Start:End] of each token
+	}{
+		{"error! at {line} key=value", []string{"error", "at", "line", "key", "=", "value"}},
+		{"mail bob@example.com from 10.0.0.1:22", []string{"mail", "bob@example.com", "from", "10.0.0.1:22"}},
+		{"took 150ms (at 2024-01-15)", []string{"took", "150ms", "(", "at", "2024-01-15", ")"}},
+		{"café  crème\n\tau lait", []string{"café", "crème", "au", "lait"}},
+		{"", nil},
+	}
+	p, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			var got []string
+			for _, tok := range p.preprocessor.Preprocess(tt.content) {
+				got = append(got, tt.content[tok.Start:tok.End])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("token offsets cover %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMappedString(t *testing.T) {
+	content := "id=#42 at https://a.b/c"
+	m := newMappedString(content).
+		filter(func(_ int, r rune) bool { return r != '#' }).
+		replace(regexp.MustCompile(`\d+`), "<N>").
+		replace(regexp.MustCompile(`https?://\S+`), "<*>").
+		spaceOut("=*")
+	if want := "id = <N> at < * >"; m.s != want {
+		t.Fatalf("s = %q, want %q", m.s, want)
+	}
+	tests := []struct {
+		start, end int // in m.s
+		want       string
+	}{
+		{0, 2, "id"},
+		{3, 4, "="},
+		{5, 8, "42"},                 // a replacement maps to what it replaced
+		{9, 11, "at"},                // copied bytes after edits
+		{12, 17, "https://a.b/c"},    // spaced out within a replacement
+		{14, 15, "https://a.b/c"},    // one byte of a replacement
+		{4, 5, ""},                   // a space alone
+		{0, len(m.s), content},       // everything
+		{len(m.s), len(m.s) + 1, ""}, // out of range
+	}
+	for _, tt := range tests {
+		start, end := m.origin(tt.start, tt.end)
+		if got := content[start:end]; got != tt.want {
+			t.Errorf("origin(%d, %d) covers %q, want %q", tt.start, tt.end, got, tt.want)
 		}
 	}
 }
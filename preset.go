@@ -0,0 +1,147 @@
+package ulp
+
+import "strings"
+
+// Preset is a built-in parser configuration for a well-known log format.
+// The Loghub presets use the log formats and preprocessing regexes of the
+// Loghub benchmark (https://github.com/logpai/loghub), so results are
+// comparable with published parser evaluations.
+type Preset struct {
+	Name        string
+	Description string
+	// Format is the Loghub log format: <Field> markers with literal text
+	// taken as regex syntax. Empty for presets with a dedicated parser.
+	Format string
+	Regex  []string // additional preprocessing patterns, see WithCustomRegex
+
+	input inputDecoder // dedicated parser, used instead of Format
+}
+
+// Presets lists the presets accepted by WithPreset.
+var Presets = []Preset{
+	{
+		Name:        "syslog-rfc3164",
+		Description: "BSD syslog as in /var/log/syslog, with optional PRI",
+		input:       &syslogInput{},
+	},
+	{
+		Name:        "syslog-rfc5424",
+		Description: "RFC 5424 syslog with structured data",
+		input:       &syslogInput{rfc5424: true},
+	},
+	{
+		Name:        "docker",
+		Description: "Docker json-file container logs, with partial lines joined",
+		input:       &dockerInput{},
+	},
+	{
+		Name:        "cri",
+		Description: "Kubernetes CRI container logs (containerd, CRI-O), with partial lines joined",
+		input:       &criInput{},
+	},
+	{
+		Name:        "HDFS",
+		Description: "Hadoop distributed file system",
+		Format:      `<Date> <Time> <Pid> <Level> <Component>: <Content>`,
+		Regex:       []string{`blk_-?\d+`, `(\d+\.){3}\d+(:\d+)?`},
+	},
+	{
+		Name:        "Hadoop",
+		Description: "Hadoop MapReduce jobs",
+		Format:      `<Date> <Time> <Level> \[<Process>\] <Component>: <Content>`,
+		Regex:       []string{`(\d+\.){3}\d+`},
+	},
+	{
+		Name:        "Spark",
+		Description: "Apache Spark",
+		Format:      `<Date> <Time> <Level> <Component>: <Content>`,
+		Regex:       []string{`(\d+\.){3}\d+`, `\b[KGTM]?B\b`, `([\w-]+\.){2,}[\w-]+`},
+	},
+	{
+		Name:        "Zookeeper",
+		Description: "Apache ZooKeeper",
+		Format:      `<Date> <Time> - <Level>  \[<Node>:<Component>@<Id>\] - <Content>`,
+		Regex:       []string{`(/|)(\d+\.){3}\d+(:\d+)?`},
+	},
+	{
+		Name:        "BGL",
+		Description: "Blue Gene/L supercomputer",
+		Format:      `<Label> <Timestamp> <Date> <Node> <Time> <NodeRepeat> <Type> <Component> <Level> <Content>`,
+		Regex:       []string{`core\.\d+`},
+	},
+	{
+		Name:        "HPC",
+		Description: "High performance cluster (Los Alamos)",
+		Format:      `<LogId> <Node> <Component> <State> <Time> <Flag> <Content>`,
+		Regex:       []string{`=\d+`},
+	},
+	{
+		Name:        "Thunderbird",
+		Description: "Thunderbird supercomputer",
+		Format:      `<Label> <Timestamp> <Date> <User> <Month> <Day> <Time> <Location> <Component>(\[<PID>\])?: <Content>`,
+		Regex:       []string{`(\d+\.){3}\d+`},
+	},
+	{
+		Name:        "Windows",
+		Description: "Windows CBS logs",
+		Format:      `<Date> <Time>, <Level>                  <Component>    <Content>`,
+		Regex:       []string{`0x.*?\s`},
+	},
+	{
+		Name:        "Linux",
+		Description: "Linux system logs",
+		Format:      `<Month> <Date> <Time> <Level> <Component>(\[<PID>\])?: <Content>`,
+		Regex:       []string{`(\d+\.){3}\d+`, `\d{2}:\d{2}:\d{2}`},
+	},
+	{
+		Name:        "Android",
+		Description: "Android framework logs",
+		Format:      `<Date> <Time>  <Pid>  <Tid> <Level> <Component>: <Content>`,
+		Regex:       []string{`(/[\w-]+)+`, `([\w-]+\.){2,}[\w-]+`, `\b(\-?\+?\d+)\b|\b0[Xx][a-fA-F\d]+\b|\b[a-fA-F\d]{4,}\b`},
+	},
+	{
+		Name:        "HealthApp",
+		Description: "Health app on Android",
+		Format:      `<Time>\|<Component>\|<Pid>\|<Content>`,
+	},
+	{
+		Name:        "Apache",
+		Description: "Apache HTTP server error log",
+		Format:      `\[<Time>\] \[<Level>\] <Content>`,
+		Regex:       []string{`(\d+\.){3}\d+`},
+	},
+	{
+		Name:        "Proxifier",
+		Description: "Proxifier proxy client",
+		Format:      `\[<Time>\] <Program> - <Content>`,
+		Regex:       []string{`<\d+\ssec`, `([\w-]+\.)+[\w-]+(:\d+)?`, `\d{2}:\d{2}(:\d{2})*`, `[KGTM]B`},
+	},
+	{
+		Name:        "OpenSSH",
+		Description: "OpenSSH server",
+		Format:      `<Date> <Day> <Time> <Component> sshd\[<Pid>\]: <Content>`,
+		Regex:       []string{`(\d+\.){3}\d+`, `([\w-]+\.){2,}[\w-]+`},
+	},
+	{
+		Name:        "OpenStack",
+		Description: "OpenStack Nova",
+		Format:      `<Logrecord> <Date> <Time> <Pid> <Level> <Component> \[<ADDR>\] <Content>`,
+		Regex:       []string{`((\d+\.){3}\d+,?)+`, `/.+?\s`, `\d+`},
+	},
+	{
+		Name:        "Mac",
+		Description: "macOS system log",
+		Format:      `<Month>  <Date> <Time> <User> <Component>\[<PID>\]( \(<Address>\))?: <Content>`,
+		Regex:       []string{`([\w-]+\.){2,}[\w-]+`},
+	},
+}
+
+// lookupPreset finds a preset by case-insensitive name.
+func lookupPreset(name string) (Preset, bool) {
+	for _, preset := range Presets {
+		if strings.EqualFold(preset.Name, name) {
+			return preset, true
+		}
+	}
+	return Preset{}, false
+}
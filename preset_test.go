@@ -0,0 +1,211 @@
+package ulp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPresetsCompile(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, preset := range Presets {
+		if seen[preset.Name] {
+			t.Errorf("duplicate preset %s", preset.Name)
+		}
+		seen[preset.Name] = true
+		if _, err := New(WithPreset(preset.Name)); err != nil {
+			t.Errorf("WithPreset(%q) error = %v", preset.Name, err)
+		}
+	}
+}
+
+func TestPresetHeaders(t *testing.T) {
+	tests := []struct {
+		preset      string
+		line        string
+		wantContent string
+		wantField   string
+		wantValue   string
+	}{
+		{
+			preset:      "HDFS",
+			line:        "081109 203615 148 INFO dfs.DataNode$PacketResponder: PacketResponder 1 for block blk_38865049064139660 terminating",
+			wantContent: "PacketResponder 1 for block blk_38865049064139660 terminating",
+			wantField:   "Component", wantValue: "dfs.DataNode$PacketResponder",
+		},
+		{
+			preset:      "Hadoop",
+			line:        "2015-10-18 18:01:47,978 INFO [main] org.apache.hadoop.mapreduce.v2.app.MRAppMaster: Created MRAppMaster for application appattempt_1445144423722_0020_000001",
+			wantContent: "Created MRAppMaster for application appattempt_1445144423722_0020_000001",
+			wantField:   "Process", wantValue: "main",
+		},
+		{
+			preset:      "Spark",
+			line:        "17/06/09 20:10:40 INFO executor.CoarseGrainedExecutorBackend: Registered signal handlers for [TERM, HUP, INT]",
+			wantContent: "Registered signal handlers for [TERM, HUP, INT]",
+			wantField:   "Component", wantValue: "executor.CoarseGrainedExecutorBackend",
+		},
+		{
+			preset:      "Zookeeper",
+			line:        "2015-07-29 17:41:44,747 - INFO  [QuorumPeer[myid=1]/0:0:0:0:0:0:0:0:2181:FastLeaderElection@774] - Notification time out: 3200",
+			wantContent: "Notification time out: 3200",
+			wantField:   "Id", wantValue: "774",
+		},
+		{
+			preset:      "BGL",
+			line:        "- 1117838570 2005.06.03 R02-M1-N0-C:J12-U11 2005-06-03-15.42.50.675872 R02-M1-N0-C:J12-U11 RAS KERNEL INFO instruction cache parity error corrected",
+			wantContent: "instruction cache parity error corrected",
+			wantField:   "Component", wantValue: "KERNEL",
+		},
+		{
+			preset:      "HPC",
+			line:        `134681 node-246 unix.hw state_change.unavailable 1077804742 1 Component State Change: Component "alt0" is in the unavailable state (HWID=1925)`,
+			wantContent: `Component State Change: Component "alt0" is in the unavailable state (HWID=1925)`,
+			wantField:   "Node", wantValue: "node-246",
+		},
+		{
+			preset:      "Thunderbird",
+			line:        "- 1131566461 2005.11.09 dn228 Nov 9 12:01:01 dn228/dn228 crond(pam_unix)[2915]: session closed for user root",
+			wantContent: "session closed for user root",
+			wantField:   "PID", wantValue: "2915",
+		},
+		{
+			preset:      "Windows",
+			line:        `2016-09-28 04:30:30, Info                  CBS    Loaded Servicing Stack v6.1.7601.23505 with Core: C:\Windows\winsxs\cbscore.dll`,
+			wantContent: `Loaded Servicing Stack v6.1.7601.23505 with Core: C:\Windows\winsxs\cbscore.dll`,
+			wantField:   "Component", wantValue: "CBS",
+		},
+		{
+			preset:      "Linux",
+			line:        "Jun 14 15:16:01 combo sshd(pam_unix)[19939]: authentication failure; logname= uid=0 euid=0 tty=NODEVssh ruser= rhost=218.188.2.4",
+			wantContent: "authentication failure; logname= uid=0 euid=0 tty=NODEVssh ruser= rhost=218.188.2.4",
+			wantField:   "Component", wantValue: "sshd(pam_unix)",
+		},
+		{
+			preset:      "Android",
+			line:        "03-17 16:13:38.811  1702  2395 D WindowManager: printFreezingDisplayLogsopening app wtoken = AppWindowToken{9f4ef63 token=Token{a64f992}}",
+			wantContent: "printFreezingDisplayLogsopening app wtoken = AppWindowToken{9f4ef63 token=Token{a64f992}}",
+			wantField:   "Tid", wantValue: "2395",
+		},
+		{
+			preset:      "HealthApp",
+			line:        "20171223-22:15:29:606|Step_LSC|30002312|onStandStepChanged 3579",
+			wantContent: "onStandStepChanged 3579",
+			wantField:   "Component", wantValue: "Step_LSC",
+		},
+		{
+			preset:      "Apache",
+			line:        "[Sun Dec 04 04:47:44 2005] [notice] workerEnv.init() ok /etc/httpd/conf/workers2.properties",
+			wantContent: "workerEnv.init() ok /etc/httpd/conf/workers2.properties",
+			wantField:   "Level", wantValue: "notice",
+		},
+		{
+			preset:      "Proxifier",
+			line:        "[10.30 16:49:06] chrome.exe - proxy.cse.cuhk.edu.hk:5070 open through proxy proxy.cse.cuhk.edu.hk:5070 HTTPS",
+			wantContent: "proxy.cse.cuhk.edu.hk:5070 open through proxy proxy.cse.cuhk.edu.hk:5070 HTTPS",
+			wantField:   "Program", wantValue: "chrome.exe",
+		},
+		{
+			preset:      "OpenSSH",
+			line:        "Dec 10 06:55:46 LabSZ sshd[24200]: reverse mapping checking getaddrinfo for ns.marryaldkfaczcz.com [173.234.31.186] failed - POSSIBLE BREAK-IN ATTEMPT!",
+			wantContent: "reverse mapping checking getaddrinfo for ns.marryaldkfaczcz.com [173.234.31.186] failed - POSSIBLE BREAK-IN ATTEMPT!",
+			wantField:   "Pid", wantValue: "24200",
+		},
+		{
+			preset:      "OpenStack",
+			line:        `nova-api.log.1.2017-05-16_13:53:08 2017-05-16 00:00:00.008 25746 INFO nova.osapi_compute.wsgi.server [req-38101a0b-2096-447d-96ea-a692162415ae - - -] 10.11.10.1 "GET /v2/servers/detail HTTP/1.1" status: 200 len: 1893 time: 0.2477829`,
+			wantContent: `10.11.10.1 "GET /v2/servers/detail HTTP/1.1" status: 200 len: 1893 time: 0.2477829`,
+			wantField:   "ADDR", wantValue: "req-38101a0b-2096-447d-96ea-a692162415ae - - -",
+		},
+		{
+			preset:      "Mac",
+			line:        "Jul  1 09:00:55 calvisitor-10-105-160-95 kernel[0]: IOThunderboltSwitch<0>(0x0)::listenerCallback - Thunderbolt HPD packet for route = 0x0 port = 11 unplug = 0",
+			wantContent: "IOThunderboltSwitch<0>(0x0)::listenerCallback - Thunderbolt HPD packet for route = 0x0 port = 11 unplug = 0",
+			wantField:   "User", wantValue: "calvisitor-10-105-160-95",
+		},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		covered[tt.preset] = true
+		t.Run(tt.preset, func(t *testing.T) {
+			p, err := New(WithPreset(tt.preset))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			content, headers, ok := p.parseHeader(tt.line)
+			if !ok {
+				t.Fatal("parseHeader() ok = false")
+			}
+			if content != tt.wantContent {
+				t.Errorf("parseHeader() content = %q, want %q", content, tt.wantContent)
+			}
+			if got := headers[tt.wantField]; got != tt.wantValue {
+				t.Errorf("parseHeader() %s = %q, want %q", tt.wantField, got, tt.wantValue)
+			}
+		})
+	}
+	for _, preset := range Presets {
+		if preset.input == nil && !covered[preset.Name] {
+			t.Errorf("no sample line for preset %s", preset.Name)
+		}
+	}
+}
+
+func TestWithPresetCaseInsensitive(t *testing.T) {
+	p, err := New(WithPreset("hdfs"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if len(p.customRegex) != 2 {
+		t.Errorf("customRegex = %v, want the 2 HDFS patterns", p.customRegex)
+	}
+}
+
+func TestWithPresetOptionOrder(t *testing.T) {
+	const line = "081109 203615 148 INFO dfs.DataNode$PacketResponder: PacketResponder 1 for block blk_38865049064139660 terminating"
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"content field first", []Option{WithContentField("Message"), WithPreset("HDFS")}},
+		{"preset first", []Option{WithPreset("HDFS"), WithContentField("Message")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			content, headers, ok := p.parseHeader(line)
+			if !ok || content != "PacketResponder 1 for block blk_38865049064139660 terminating" {
+				t.Errorf("parseHeader() content = %q, ok = %v", content, ok)
+			}
+			if headers["Component"] != "dfs.DataNode$PacketResponder" {
+				t.Errorf("parseHeader() headers = %v", headers)
+			}
+			if p.contentField != "Message" {
+				t.Errorf("contentField = %q, want Message", p.contentField)
+			}
+		})
+	}
+}
+
+func TestModelRoundTripPreset(t *testing.T) {
+	p, err := New(WithPreset("OpenSSH"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.SaveModel(&buf, nil); err != nil {
+		t.Fatalf("SaveModel() error = %v", err)
+	}
+	loaded, _, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+
+	line := "Dec 10 06:55:46 LabSZ sshd[24200]: Failed password for root from 173.234.31.186 port 38926 ssh2"
+	if got, want := loaded.newEvent(rawRecord{text: line}), p.newEvent(rawRecord{text: line}); got.TokenString != want.TokenString {
+		t.Errorf("loaded parser tokens = %q, want %q", got.TokenString, want.TokenString)
+	}
+}
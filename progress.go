@@ -0,0 +1,110 @@
+package ulp
+
+import (
+	"io"
+	"sync"
+)
+
+// progressLineInterval is the number of lines between progress reports
+// while reading.
+const progressLineInterval = 10000
+
+// Progress is a snapshot of parsing progress passed to the callback set
+// with WithProgress.
+type Progress struct {
+	LinesRead       int   // non-empty lines read so far
+	BytesRead       int64 // bytes consumed from the input
+	Groups          int   // groups formed; known once reading is done
+	GroupsTemplated int   // groups whose template has been generated
+}
+
+// progressTracker accumulates progress and serializes callbacks coming from
+// the reader and the worker pool. A nil tracker ignores all updates.
+type progressTracker struct {
+	mu     sync.Mutex
+	fn     func(Progress)
+	cur    Progress
+	parent *progressTracker // set for trackers returned by source
+}
+
+// newProgressTracker returns a tracker for the parser's progress callback,
+// or nil if none is set.
+func (p *Parser) newProgressTracker() *progressTracker {
+	if p.progress == nil {
+		return nil
+	}
+	return &progressTracker{fn: p.progress}
+}
+
+// source returns a tracker for one of several inputs read concurrently,
+// whose reads are added up in t.
+func (t *progressTracker) source() *progressTracker {
+	if t == nil {
+		return nil
+	}
+	return &progressTracker{parent: t}
+}
+
+// read reports the number of lines and bytes read so far.
+func (t *progressTracker) read(lines int, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.parent != nil {
+		t.parent.add(lines-t.cur.LinesRead, bytes-t.cur.BytesRead)
+		t.cur.LinesRead = lines
+		t.cur.BytesRead = bytes
+		return
+	}
+	t.cur.LinesRead = lines
+	t.cur.BytesRead = bytes
+	t.fn(t.cur)
+}
+
+// add reports additional lines and bytes read.
+func (t *progressTracker) add(lines int, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cur.LinesRead += lines
+	t.cur.BytesRead += bytes
+	t.fn(t.cur)
+}
+
+// grouped reports the number of groups formed after reading.
+func (t *progressTracker) grouped(groups int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cur.Groups = groups
+	t.fn(t.cur)
+}
+
+// templated reports the number of groups templated so far. Workers may
+// report out of order, so the count never goes backwards.
+func (t *progressTracker) templated(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n > t.cur.GroupsTemplated {
+		t.cur.GroupsTemplated = n
+		t.fn(t.cur)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package ulp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWithProgress(t *testing.T) {
+	var lines []string
+	for i := 0; i < 25000; i++ {
+		lines = append(lines, fmt.Sprintf("worker %d handled request %d", i%3, i))
+	}
+	input := strings.Join(lines, "\n") + "\n"
+
+	var reports []Progress
+	p, err := New(WithProgress(func(pr Progress) {
+		reports = append(reports, pr)
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := p.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(reports) == 0 {
+		t.Fatal("no progress reported")
+	}
+
+	// Periodic reports while reading
+	if reports[0].LinesRead != progressLineInterval || reports[1].LinesRead != 2*progressLineInterval {
+		t.Errorf("first reports = %+v, want lines at every %d", reports[:2], progressLineInterval)
+	}
+
+	for i := 1; i < len(reports); i++ {
+		prev, cur := reports[i-1], reports[i]
+		if cur.LinesRead < prev.LinesRead || cur.BytesRead < prev.BytesRead || cur.GroupsTemplated < prev.GroupsTemplated {
+			t.Errorf("progress went backwards: %+v -> %+v", prev, cur)
+		}
+	}
+
+	last := reports[len(reports)-1]
+	want := Progress{
+		LinesRead:       25000,
+		BytesRead:       int64(len(input)),
+		Groups:          len(result.Groups),
+		GroupsTemplated: len(result.Groups),
+	}
+	if last != want {
+		t.Errorf("final progress = %+v, want %+v", last, want)
+	}
+}
+
+func TestProgressTrackerNil(t *testing.T) {
+	var tr *progressTracker
+	// Must not panic without a callback
+	tr.read(1, 1)
+	tr.grouped(1)
+	tr.templated(1)
+}
@@ -0,0 +1,98 @@
+package ulp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"strings"
+	"time"
+)
+
+// ParseStream learns templates from r with memory proportional to the number
+// of groups rather than the number of lines. Each group only keeps the
+// tokens of its first event and the subset of them seen in every event so
+// far, which yields the same templates as Parse without sampling, so
+// WithSampleSize has no effect here.
+//
+// The result holds Templates and Groups (with Count but no Events). Use
+// StreamEvents on a second pass over the same input to obtain the events.
+func (p *Parser) ParseStream(r io.Reader) (*ParseResult, error) {
+	start := time.Now()
+	var stats ParseStats
+	scan := p.scanOptions(&stats)
+	progress := scan.progress
+
+	states := make(map[string]*groupState)
+	err := p.scanEvents(context.Background(), r, scan, func(ev *LogEvent) error {
+		ev.EventID = generateEventID(ev.TokenString)
+		if g, ok := states[ev.EventID]; ok {
+			g.add(ev)
+		} else {
+			states[ev.EventID] = newGroupState(ev)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	progress.grouped(len(states))
+	for _, g := range states {
+		g.template = g.buildTemplate(p.dynamicWildcard, p.replaceNumbers)
+	}
+	progress.templated(len(states))
+	groups := groupsFromStates(states)
+
+	return &ParseResult{
+		Templates:    mergeGroupsWithSimilarTemplates(groups, p.dynamicWildcard),
+		Groups:       groups,
+		HeaderFields: p.headerFields(scan.fields),
+		Stats:        stats,
+		Duration:     time.Since(start),
+	}, nil
+}
+
+// StreamEvents reads log lines from r, typically the same input again after
+// ParseStream, and yields each event with its EventID, TemplateID and
+// Parameters assigned from result. Events are not retained, so arbitrarily
+// large inputs can be processed. Lines whose group is unknown to result are
+// yielded with an empty TemplateID. A read error is yielded last.
+func (p *Parser) StreamEvents(r io.Reader, result *ParseResult) iter.Seq2[*LogEvent, error] {
+	return func(yield func(*LogEvent, error) bool) {
+		templateByEventID := make(map[string]*LogTemplate)
+		tokensByID := make(map[string][]string, len(result.Templates))
+		for _, tmpl := range result.Templates {
+			for _, eid := range tmpl.EventIDs {
+				templateByEventID[eid] = tmpl
+			}
+			tokensByID[tmpl.TemplateID] = strings.Fields(tmpl.Template)
+		}
+
+		stopped := false
+		// Rejects were already written by ParseStream
+		scan := scanOptions{progress: p.newProgressTracker()}
+		err := p.scanEvents(context.Background(), r, scan, func(ev *LogEvent) error {
+			ev.EventID = generateEventID(ev.TokenString)
+			if tmpl, ok := templateByEventID[ev.EventID]; ok {
+				ev.TemplateID = tmpl.TemplateID
+				if !p.skipParameters {
+					tokens := strings.Fields(ev.TokenString)
+					spans, _ := matchTemplate(tokensByID[tmpl.TemplateID], tokens, p.dynamicWildcard, p.placeholders)
+					ev.Parameters = locateParameters(ev, tokens, spans)
+				}
+			}
+			if !yield(ev, nil) {
+				stopped = true
+				return errStopStream
+			}
+			return nil
+		})
+		if err != nil && !stopped {
+			yield(nil, err)
+		}
+	}
+}
+
+// errStopStream aborts scanning when the consumer of StreamEvents stops early.
+var errStopStream = errors.New("stream stopped")
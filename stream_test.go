@@ -0,0 +1,121 @@
+package ulp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseStreamMatchesParse(t *testing.T) {
+	data, err := os.ReadFile("testdata/hdfs_sample.log")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+
+	p, err := New(WithHeaderFormat("<Date> <Time> <Pid> <Level> <Component>: <Content>"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	want, err := p.Parse(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got, err := p.ParseStream(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("ParseStream() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.Templates, want.Templates) {
+		t.Errorf("ParseStream() templates differ:\n got %+v\nwant %+v", got.Templates, want.Templates)
+	}
+	if got.Events != nil {
+		t.Errorf("ParseStream() should not retain events")
+	}
+
+	var events []*LogEvent
+	for ev, err := range p.StreamEvents(strings.NewReader(string(data)), got) {
+		if err != nil {
+			t.Fatalf("StreamEvents() error = %v", err)
+		}
+		events = append(events, ev)
+	}
+	if !reflect.DeepEqual(events, want.Events) {
+		t.Errorf("StreamEvents() events differ from Parse()")
+	}
+}
+
+func TestStreamEventsStopEarly(t *testing.T) {
+	input := "msg 1\nmsg 2\nmsg 3\n"
+	p, _ := New()
+	result, err := p.ParseStream(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseStream() error = %v", err)
+	}
+
+	n := 0
+	for _, err := range p.StreamEvents(strings.NewReader(input), result) {
+		if err != nil {
+			t.Fatalf("StreamEvents() error = %v", err)
+		}
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected to stop after 2 events, got %d", n)
+	}
+}
+
+func TestStreamEventsReadError(t *testing.T) {
+	p, _ := New()
+	readErr := errors.New("disk failure")
+	r := io.MultiReader(strings.NewReader("msg 1\n"), iotest.ErrReader(readErr))
+
+	var gotErr error
+	n := 0
+	for ev, err := range p.StreamEvents(r, &ParseResult{}) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		if ev.TemplateID != "" {
+			t.Errorf("unknown group got TemplateID %q", ev.TemplateID)
+		}
+		n++
+	}
+	if n != 1 || !errors.Is(gotErr, readErr) {
+		t.Errorf("got %d events and error %v, want 1 event and %v", n, gotErr, readErr)
+	}
+}
+
+func BenchmarkParseStream10000Lines(b *testing.B) {
+	var lines []string
+	templates := []string{
+		"PacketResponder %d for block blk_%d terminating",
+		"Received block blk_%d of size 67108864 from /10.251.%d.%d",
+		"BLOCK* NameSystem.addStoredBlock: blockMap updated: 10.250.%d.%d:50010 is added to blk_%d size 67108864",
+	}
+
+	for i := 0; i < 10000; i++ {
+		tmpl := templates[i%len(templates)]
+		lines = append(lines, fmt.Sprintf(tmpl, i, i*31, i*17))
+	}
+	input := strings.Join(lines, "\n")
+
+	p, _ := New()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := p.ParseStream(strings.NewReader(input))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
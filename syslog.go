@@ -0,0 +1,150 @@
+package ulp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Header fields extracted from syslog messages.
+var (
+	syslogRFC3164Fields = []string{"Timestamp", "Hostname", "App", "Pid", "Severity", "Facility"}
+	syslogRFC5424Fields = []string{"Timestamp", "Hostname", "App", "Pid", "MsgID", "StructuredData", "Severity", "Facility"}
+)
+
+// syslogRFC3164 matches BSD syslog lines, with or without the PRI part as
+// written to /var/log/syslog, e.g. "<34>Oct 11 22:14:15 mymachine su[123]: msg".
+// rsyslog's high-precision RFC 3339 timestamps are accepted as well.
+var syslogRFC3164 = regexp.MustCompile(`^(?:<(\d{1,3})>)?` +
+	`([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+)\s+` +
+	`(\S+)\s+` +
+	`(?:([^\s\[:]+)(?:\[(\d+)\])?:(?:\s+|$))?` +
+	`(.*)$`)
+
+// syslogRFC5424Header matches the fixed part of an RFC 5424 message up to
+// the structured data.
+var syslogRFC5424Header = regexp.MustCompile(`^<(\d{1,3})>\d{1,2} (\S+) (\S+) (\S+) (\S+) (\S+) (.*)$`)
+
+var (
+	syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+)
+
+// syslogInput decodes RFC 3164 or RFC 5424 syslog messages into the message
+// as content and the hostname, app name, pid, severity and so on as headers.
+type syslogInput struct {
+	rfc5424 bool
+}
+
+func (in *syslogInput) decode(line string) (content string, headers map[string]string, ok bool) {
+	line = strings.TrimSpace(line)
+	if in.rfc5424 {
+		return decodeRFC5424(line)
+	}
+	return decodeRFC3164(line)
+}
+
+func (in *syslogInput) fieldNames() []string {
+	if in.rfc5424 {
+		return syslogRFC5424Fields
+	}
+	return syslogRFC3164Fields
+}
+
+func decodeRFC3164(line string) (content string, headers map[string]string, ok bool) {
+	m := syslogRFC3164.FindStringSubmatch(line)
+	if m == nil {
+		return line, nil, false
+	}
+	headers = make(map[string]string, len(syslogRFC3164Fields))
+	setSyslogPriority(headers, m[1])
+	setSyslogField(headers, "Timestamp", m[2])
+	setSyslogField(headers, "Hostname", m[3])
+	setSyslogField(headers, "App", m[4])
+	setSyslogField(headers, "Pid", m[5])
+	return strings.TrimSpace(m[6]), headers, true
+}
+
+func decodeRFC5424(line string) (content string, headers map[string]string, ok bool) {
+	m := syslogRFC5424Header.FindStringSubmatch(line)
+	if m == nil {
+		return line, nil, false
+	}
+	sd, msg, valid := splitStructuredData(m[7])
+	if !valid {
+		return line, nil, false
+	}
+	headers = make(map[string]string, len(syslogRFC5424Fields))
+	setSyslogPriority(headers, m[1])
+	setSyslogField(headers, "Timestamp", m[2])
+	setSyslogField(headers, "Hostname", m[3])
+	setSyslogField(headers, "App", m[4])
+	setSyslogField(headers, "Pid", m[5])
+	setSyslogField(headers, "MsgID", m[6])
+	setSyslogField(headers, "StructuredData", sd)
+	// The message may start with a UTF-8 byte order mark
+	msg = strings.TrimPrefix(strings.TrimSpace(msg), "\ufeff")
+	return msg, headers, true
+}
+
+// splitStructuredData splits the STRUCTURED-DATA part of an RFC 5424
+// message, either "-" or one or more [id param="value"] elements, from the
+// message that follows it.
+func splitStructuredData(s string) (sd, msg string, ok bool) {
+	if s == "-" || strings.HasPrefix(s, "- ") {
+		return "-", s[1:], true
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		end := sdElementEnd(s, i)
+		if end == -1 {
+			return "", "", false
+		}
+		i = end + 1
+	}
+	if i == 0 || (i < len(s) && s[i] != ' ') {
+		return "", "", false
+	}
+	return s[:i], s[i:], true
+}
+
+// sdElementEnd returns the index of the "]" closing the structured data
+// element that starts at s[start], or -1. Inside quoted parameter values
+// '"', '\' and ']' may be escaped with a backslash.
+func sdElementEnd(s string, start int) int {
+	inQuotes := false
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && inQuotes:
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ']' && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+// setSyslogField sets a header unless the value is empty or the RFC 5424
+// nil value "-".
+func setSyslogField(headers map[string]string, name, value string) {
+	if value != "" && value != "-" {
+		headers[name] = value
+	}
+}
+
+// setSyslogPriority decodes a PRI value into the Severity and Facility
+// headers.
+func setSyslogPriority(headers map[string]string, pri string) {
+	n, err := strconv.Atoi(pri)
+	if err != nil || n > 191 {
+		return
+	}
+	headers["Severity"] = syslogSeverities[n%8]
+	headers["Facility"] = syslogFacilities[n/8]
+}
@@ -0,0 +1,220 @@
+package ulp
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readTestLines(t *testing.T, name string) []string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+func TestSyslogRFC3164Decode(t *testing.T) {
+	lines := readTestLines(t, "testdata/syslog_rfc3164.log")
+	tests := []struct {
+		line        int
+		wantContent string
+		wantHeaders map[string]string
+	}{
+		{
+			line:        0,
+			wantContent: "Accepted publickey for deploy from 10.0.0.5 port 52144 ssh2",
+			wantHeaders: map[string]string{"Timestamp": "Jan 15 10:30:22", "Hostname": "web01", "App": "sshd", "Pid": "2154"},
+		},
+		{
+			line:        4,
+			wantContent: "[12345.678901] eth0: Link is Up - 1Gbps/Full",
+			wantHeaders: map[string]string{"Timestamp": "Jan 15 10:32:47", "Hostname": "web01", "App": "kernel"},
+		},
+		{
+			line:        5,
+			wantContent: "Accepted publickey for admin from 10.0.0.9 port 40022 ssh2",
+			wantHeaders: map[string]string{"Timestamp": "Jan  5 10:33:12", "Hostname": "web02", "App": "sshd", "Pid": "3310"},
+		},
+		{
+			line:        6,
+			wantContent: "deploy : TTY=pts/0 ; PWD=/home/deploy ; USER=root ; COMMAND=/bin/systemctl restart nginx",
+			wantHeaders: map[string]string{
+				"Timestamp": "Jan 15 10:34:00", "Hostname": "web02", "App": "sudo",
+				"Severity": "info", "Facility": "authpriv",
+			},
+		},
+		{
+			line:        7,
+			wantContent: "Started Session 43 of user admin.",
+			wantHeaders: map[string]string{"Timestamp": "2024-01-15T10:35:10.123456+00:00", "Hostname": "web02", "App": "systemd", "Pid": "1"},
+		},
+		{
+			line:        8,
+			wantContent: "-- MARK --",
+			wantHeaders: map[string]string{"Timestamp": "Jan 15 10:36:00", "Hostname": "web01"},
+		},
+	}
+
+	in := &syslogInput{}
+	for _, tt := range tests {
+		t.Run(lines[tt.line], func(t *testing.T) {
+			content, headers, ok := in.decode(lines[tt.line])
+			if !ok {
+				t.Fatal("decode() ok = false")
+			}
+			if content != tt.wantContent {
+				t.Errorf("decode() content = %q, want %q", content, tt.wantContent)
+			}
+			if !reflect.DeepEqual(headers, tt.wantHeaders) {
+				t.Errorf("decode() headers = %v, want %v", headers, tt.wantHeaders)
+			}
+		})
+	}
+
+	if _, _, ok := in.decode("not a syslog line"); ok {
+		t.Error("decode() ok = true for a non-syslog line")
+	}
+}
+
+func TestSyslogRFC5424Decode(t *testing.T) {
+	lines := readTestLines(t, "testdata/syslog_rfc5424.log")
+	tests := []struct {
+		line        int
+		wantContent string
+		wantHeaders map[string]string
+		wantOK      bool
+	}{
+		{
+			line:        0,
+			wantContent: "'su root' failed for lonvick on /dev/pts/8",
+			wantHeaders: map[string]string{
+				"Timestamp": "2003-10-11T22:14:15.003Z", "Hostname": "mymachine.example.com", "App": "su",
+				"MsgID": "ID47", "Severity": "crit", "Facility": "auth",
+			},
+			wantOK: true,
+		},
+		{
+			line:        1,
+			wantContent: "%% It's time to make the do-nuts.",
+			wantHeaders: map[string]string{
+				"Timestamp": "2003-08-24T05:14:15.000003-07:00", "Hostname": "192.0.2.1", "App": "myproc",
+				"Pid": "8710", "Severity": "notice", "Facility": "local4",
+			},
+			wantOK: true,
+		},
+		{
+			line:        2,
+			wantContent: "An application event log entry...",
+			wantHeaders: map[string]string{
+				"Timestamp": "2003-10-11T22:14:15.003Z", "Hostname": "mymachine.example.com", "App": "evntslog",
+				"MsgID":          "ID47",
+				"StructuredData": `[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"]`,
+				"Severity":       "notice", "Facility": "local4",
+			},
+			wantOK: true,
+		},
+		{
+			line:        3,
+			wantContent: "",
+			wantHeaders: map[string]string{
+				"Timestamp": "2003-10-11T22:14:15.003Z", "Hostname": "mymachine.example.com", "App": "evntslog",
+				"MsgID":          "ID47",
+				"StructuredData": `[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high"]`,
+				"Severity":       "notice", "Facility": "local4",
+			},
+			wantOK: true,
+		},
+		{
+			line:        4,
+			wantContent: "upstream timed out while reading response header",
+			wantHeaders: map[string]string{
+				"Timestamp": "2024-01-15T10:30:22Z", "Hostname": "web01", "App": "nginx", "Pid": "1234",
+				"StructuredData": `[meta note="a \] b"]`,
+				"Severity":       "notice", "Facility": "user",
+			},
+			wantOK: true,
+		},
+		{
+			line:        5,
+			wantContent: "not a syslog line",
+		},
+	}
+
+	in := &syslogInput{rfc5424: true}
+	for _, tt := range tests {
+		t.Run(lines[tt.line], func(t *testing.T) {
+			content, headers, ok := in.decode(lines[tt.line])
+			if ok != tt.wantOK {
+				t.Fatalf("decode() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if content != tt.wantContent {
+				t.Errorf("decode() content = %q, want %q", content, tt.wantContent)
+			}
+			if !reflect.DeepEqual(headers, tt.wantHeaders) {
+				t.Errorf("decode() headers = %v, want %v", headers, tt.wantHeaders)
+			}
+		})
+	}
+}
+
+func TestSplitStructuredData(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantSD  string
+		wantMsg string
+		wantOK  bool
+	}{
+		{"-", "-", "", true},
+		{"- hello", "-", " hello", true},
+		{`[a b="1"] hello`, `[a b="1"]`, " hello", true},
+		{`[a b="]"][c]`, `[a b="]"][c]`, "", true},
+		{`[a b="1"`, "", "", false},
+		{`[a]hello`, "", "", false},
+		{"hello", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			sd, msg, ok := splitStructuredData(tt.input)
+			if sd != tt.wantSD || msg != tt.wantMsg || ok != tt.wantOK {
+				t.Errorf("splitStructuredData() = %q, %q, %v; want %q, %q, %v",
+					sd, msg, ok, tt.wantSD, tt.wantMsg, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseSyslogPreset(t *testing.T) {
+	result := parseFile(t, "testdata/syslog_rfc3164.log", WithPreset("syslog-rfc3164"))
+
+	if got, want := result.HeaderFields, syslogRFC3164Fields; !reflect.DeepEqual(got, want) {
+		t.Errorf("HeaderFields = %v, want %v", got, want)
+	}
+	if result.Stats.HeaderMismatches != 0 {
+		t.Errorf("Stats.HeaderMismatches = %d, want 0", result.Stats.HeaderMismatches)
+	}
+
+	templates := make(map[string]int)
+	for _, tmpl := range result.Templates {
+		templates[tmpl.Template] = tmpl.Count
+		// Timestamps and hostnames are headers, not part of the templates
+		if strings.Contains(tmpl.Template, "Jan") || strings.Contains(tmpl.Template, "web0") {
+			t.Errorf("template contains header fields: %s", tmpl.Template)
+		}
+	}
+	if want := "Started Session <*> of user <*>"; templates[want] != 2 {
+		t.Errorf("template %q count = %d, want 2; templates: %v", want, templates[want], templates)
+	}
+	if ev := result.Events[1]; ev.Headers["App"] != "sshd" || ev.Headers["Pid"] != "2154" {
+		t.Errorf("event 2 headers = %v", ev.Headers)
+	}
+}
+
+func TestWithPresetUnknown(t *testing.T) {
+	_, err := New(WithPreset("syslog"))
+	if err == nil || !strings.Contains(err.Error(), "syslog-rfc3164") {
+		t.Errorf("New() error = %v, want list of available presets", err)
+	}
+}
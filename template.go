@@ -134,13 +134,13 @@ func mergeGroupsWithSimilarTemplates(groups []*LogGroup, wildcard string) []*Log
 
 		if lt, ok := templateMap[normalized]; ok {
 			lt.EventIDs = append(lt.EventIDs, g.EventID)
-			lt.Count += len(g.Events)
+			lt.Count += g.size()
 		} else {
 			lt := &LogTemplate{
 				TemplateID: intToStr(len(templateMap) + 1),
 				Template:   normalized,
 				EventIDs:   []string{g.EventID},
-				Count:      len(g.Events),
+				Count:      g.size(),
 			}
 			templateMap[normalized] = lt
 			templateOrder = append(templateOrder, normalized)
@@ -1,20 +1,49 @@
 package ulp
 
-import "time"
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
 
-// LogEvent represents a single log line after preprocessing.
+// LogEvent represents a single log line, or a multi-line event, after preprocessing.
 type LogEvent struct {
 	LineID      int
+	Source      string // input file, set by ParseFiles
+	StartLine   int    // physical line number of the first line in the input (file)
+	EndLine     int    // physical line number of the last line in the input (file)
 	RawContent  string // original message content (header removed)
 	TokenString string // preprocessed token string
-	EventID     string // group identifier
-	TemplateID  string // final template identifier
+	// TokenOffsets holds the byte offsets in RawContent of the text each
+	// token of TokenString was made from, end exclusive; equal offsets mean
+	// the token has no counterpart in RawContent, see Token.
+	TokenOffsets [][2]int
+	EventID      string            // group identifier
+	TemplateID   string            // final template identifier
+	Parameters   []Parameter       // dynamic values matched by the template wildcards
+	Headers      map[string]string // header fields parsed from the line, keyed by name
+	// HeaderMatched is false if the line didn't fit the header format.
+	// It is always true without a header format.
+	HeaderMatched bool
+}
+
+// Parameter is a dynamic value extracted from an event for one template wildcard.
+type Parameter struct {
+	Position int // index of the first matched token in TokenString
+	// Value is the text of RawContent between Start and End or, if the
+	// matched tokens have no offsets, the tokens themselves, space-separated.
+	Value string
+	Type  string // placeholder type such as "IP" for typed placeholders, see WithTypedPlaceholders
+	// Start and End are the byte offsets of the value in RawContent, end
+	// exclusive, or -1 if unknown
+	Start, End int
 }
 
 // LogGroup represents a cluster of events sharing the same EventID.
 type LogGroup struct {
 	EventID  string
 	Events   []*LogEvent
+	Count    int // number of events; groups built without retaining Events only carry Count
 	Template string
 }
 
@@ -24,25 +53,81 @@ type LogTemplate struct {
 	Template   string
 	EventIDs   []string // EventIDs that share this template
 	Count      int      // total number of events matching this template
+	// SourceCounts holds the number of events per input file, set by ParseFiles.
+	SourceCounts map[string]int
 }
 
 // ParseResult holds the complete output of the parsing process.
 type ParseResult struct {
-	Events    []*LogEvent
-	Templates []*LogTemplate
-	Groups    []*LogGroup
-	Duration  time.Duration
+	Events       []*LogEvent
+	Templates    []*LogTemplate
+	Groups       []*LogGroup
+	HeaderFields []string // header field names in format order (content field excluded)
+	Sources      []string // input files in LineID order, set by ParseFiles
+	Stats        ParseStats
+	Duration     time.Duration
+}
+
+// ParseStats holds counters collected while reading the input.
+type ParseStats struct {
+	Lines            int // events read (multi-line events count once)
+	HeaderMismatches int // events whose header didn't match the header format
+	Skipped          int // mismatched events dropped by HeaderMismatchSkip
+}
+
+// HeaderMismatchPolicy determines what happens to a line that doesn't fit
+// the header format.
+type HeaderMismatchPolicy int
+
+const (
+	// HeaderMismatchKeep keeps whatever header parsing produced: with a
+	// separator-based format the text after the last separator found.
+	HeaderMismatchKeep HeaderMismatchPolicy = iota
+	// HeaderMismatchWholeLine uses the whole line as content, without headers.
+	HeaderMismatchWholeLine
+	// HeaderMismatchSkip drops the line.
+	HeaderMismatchSkip
+)
+
+var headerMismatchNames = []string{"keep", "whole-line", "skip"}
+
+// String returns the policy name as accepted by ParseHeaderMismatchPolicy.
+func (m HeaderMismatchPolicy) String() string {
+	if m < 0 || int(m) >= len(headerMismatchNames) {
+		return fmt.Sprintf("HeaderMismatchPolicy(%d)", int(m))
+	}
+	return headerMismatchNames[m]
+}
+
+// ParseHeaderMismatchPolicy returns the policy named "keep", "whole-line"
+// or "skip".
+func ParseHeaderMismatchPolicy(name string) (HeaderMismatchPolicy, error) {
+	for i, n := range headerMismatchNames {
+		if n == name {
+			return HeaderMismatchPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown header mismatch policy %q (want keep, whole-line or skip)", name)
 }
 
 // HeaderFormat describes how to parse the log header.
-// Fields are extracted by name from the format string, e.g. "<Date> <Time> <Pid> <Level> <Component>: <Content>".
+// Fields are extracted by name from the format string, e.g. "<Date> <Time> <Pid> <Level> <Component>: <Content>",
+// or from the named groups of a regular expression.
 type HeaderFormat struct {
 	Format       string
 	ContentField string
 	fields       []headerField
+	regex        *regexp.Regexp // set for regex-based formats, see WithHeaderRegex
 }
 
 type headerField struct {
 	name      string
 	separator string // separator after this field (empty for last field)
 }
+
+// TemplateChange describes a group template update in online mode.
+type TemplateChange struct {
+	EventID     string
+	OldTemplate string // empty when the group was just created
+	NewTemplate string
+}
@@ -1,26 +1,52 @@
 package ulp
 
 import (
-	"bufio"
+	"context"
 	"io"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Parse reads log lines from r and returns parsed results with templates.
 // This implements Algorithm 1 from the ULP paper (ICSME 2022).
 func (p *Parser) Parse(r io.Reader) (*ParseResult, error) {
+	return p.ParseContext(context.Background(), r)
+}
+
+// ParseContext is like Parse but stops reading and template generation
+// as soon as ctx is done, returning ctx.Err().
+func (p *Parser) ParseContext(ctx context.Context, r io.Reader) (*ParseResult, error) {
 	start := time.Now()
+	var stats ParseStats
+	scan := p.scanOptions(&stats)
 
 	// Step 1: Read and preprocess all lines
-	events, err := p.readAndPreprocess(r)
+	events, err := p.readAndPreprocess(ctx, r, scan)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.learn(ctx, events, scan.progress)
 	if err != nil {
 		return nil, err
 	}
+	result.HeaderFields = p.headerFields(scan.fields)
+	result.Stats = stats
+	result.Duration = time.Since(start)
+	return result, nil
+}
 
+// learn runs the remaining steps of Parse over preprocessed events: it
+// groups them, generates and merges templates and assigns TemplateIDs and
+// parameters back to the events.
+func (p *Parser) learn(ctx context.Context, events []*LogEvent, progress *progressTracker) (*ParseResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if len(events) == 0 {
-		return &ParseResult{Duration: time.Since(start)}, nil
+		return &ParseResult{}, nil
 	}
 
 	// Step 2: Generate EventIDs and group events
@@ -37,9 +63,12 @@ func (p *Parser) Parse(r io.Reader) (*ParseResult, error) {
 	sort.Slice(groups, func(i, j int) bool {
 		return groups[i].Events[0].LineID < groups[j].Events[0].LineID
 	})
+	progress.grouped(len(groups))
 
 	// Step 3: Generate templates (parallel via worker pool)
-	p.generateTemplatesParallel(groups)
+	if err := p.generateTemplatesParallel(ctx, groups, progress); err != nil {
+		return nil, err
+	}
 
 	// Step 4: Merge groups with similar templates
 	templates := mergeGroupsWithSimilarTemplates(groups, p.dynamicWildcard)
@@ -55,50 +84,78 @@ func (p *Parser) Parse(r io.Reader) (*ParseResult, error) {
 		ev.TemplateID = templateByEventID[ev.EventID]
 	}
 
+	// Step 5: Extract dynamic parameters against the final templates
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !p.skipParameters {
+		extractParameters(events, templates, p.dynamicWildcard, p.placeholders)
+	}
+
 	return &ParseResult{
 		Events:    events,
 		Templates: templates,
 		Groups:    groups,
-		Duration:  time.Since(start),
 	}, nil
 }
 
 // readAndPreprocess reads log lines from r and creates preprocessed LogEvents.
-func (p *Parser) readAndPreprocess(r io.Reader) ([]*LogEvent, error) {
-	scanner := bufio.NewScanner(r)
-	// Allow long lines (up to 1MB)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-
+func (p *Parser) readAndPreprocess(ctx context.Context, r io.Reader, opts scanOptions) ([]*LogEvent, error) {
 	var events []*LogEvent
-	lineID := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-		lineID++
-
-		content := p.extractContent(line)
-		tokenString := p.preprocess(content)
-
-		events = append(events, &LogEvent{
-			LineID:      lineID,
-			RawContent:  content,
-			TokenString: tokenString,
-		})
+	err := p.scanEvents(ctx, r, opts, func(ev *LogEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return events, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+// newEvent extracts the content and header fields of a log record and
+// preprocesses the content into a LogEvent. The LineID is left for the
+// caller to assign.
+func (p *Parser) newEvent(rec rawRecord) *LogEvent {
+	var content string
+	var headers map[string]string
+	var ok bool
+	if rec.decoded != nil {
+		content, headers, ok = rec.decoded.content, rec.decoded.headers, rec.decoded.ok
+	} else {
+		content, headers, ok = p.parseHeader(rec.text)
+	}
+	tokens := p.preprocessor.Preprocess(content)
+	if p.foldKeys && p.input != nil && ok {
+		tokens = p.foldFields(tokens, headers)
+	}
+	tokens = splitTokens(tokens, content)
+	offsets := make([][2]int, len(tokens))
+	for i, tok := range tokens {
+		offsets[i] = [2]int{tok.Start, tok.End}
 	}
+	return &LogEvent{
+		StartLine:     rec.startLine,
+		EndLine:       rec.endLine,
+		RawContent:    content,
+		TokenString:   joinTokens(tokens),
+		TokenOffsets:  offsets,
+		Headers:       headers,
+		HeaderMatched: ok,
+	}
+}
 
-	return events, nil
+// skipEvent reports whether ev is dropped by the header mismatch policy.
+func (p *Parser) skipEvent(ev *LogEvent) bool {
+	return !ev.HeaderMatched && p.headerMismatch == HeaderMismatchSkip
 }
 
 // generateTemplatesParallel processes groups through a worker pool.
-func (p *Parser) generateTemplatesParallel(groups []*LogGroup) {
+// If ctx is done, remaining groups are skipped and ctx.Err() is returned
+// once all workers have exited. Progress is reported roughly every percent
+// of the groups.
+func (p *Parser) generateTemplatesParallel(ctx context.Context, groups []*LogGroup, progress *progressTracker) error {
 	if len(groups) == 0 {
-		return
+		return nil
 	}
 
 	workers := p.maxWorkers
@@ -108,21 +165,33 @@ func (p *Parser) generateTemplatesParallel(groups []*LogGroup) {
 
 	ch := make(chan *LogGroup, len(groups))
 	var wg sync.WaitGroup
+	var done atomic.Int64
+	step := int64(max(1, len(groups)/100))
 
 	// Start workers
 	for i := 0; i < workers; i++ {
 		wg.Go(func() {
 			for g := range ch {
+				if ctx.Err() != nil {
+					continue // drain remaining groups
+				}
 				g.Template = generateTemplate(g, p.dynamicWildcard, p.sampleSize, p.replaceNumbers)
+				if n := done.Add(1); n%step == 0 || n == int64(len(groups)) {
+					progress.templated(int(n))
+				}
 			}
 		})
 	}
 
 	// Send groups to workers
 	for _, g := range groups {
+		if ctx.Err() != nil {
+			break
+		}
 		ch <- g
 	}
 	close(ch)
 
 	wg.Wait()
+	return ctx.Err()
 }
@@ -1,8 +1,11 @@
 package ulp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
@@ -72,6 +75,13 @@ func TestParseHDFSSample(t *testing.T) {
 		t.Errorf("largest template count = %d, want 6", result.Templates[0].Count)
 	}
 
+	if got := result.Events[0].Headers["Level"]; got != "INFO" {
+		t.Errorf("event 1 Level header = %q, want INFO", got)
+	}
+	if len(result.HeaderFields) != 5 {
+		t.Errorf("expected 5 header fields, got %v", result.HeaderFields)
+	}
+
 	t.Logf("Parse took %v", result.Duration)
 	for _, tmpl := range result.Templates {
 		t.Logf("Template %s (count=%d): %s", tmpl.TemplateID, tmpl.Count, tmpl.Template)
@@ -244,6 +254,226 @@ msg A 3
 	}
 }
 
+func TestParseHeaderRegexRejects(t *testing.T) {
+	input := `[10:30:22] INFO Task 1 finished
+garbage without header
+[10:30:23] INFO Task 2 finished
+[10:30:24] broken
+`
+	var rejects strings.Builder
+	p, err := New(
+		WithHeaderRegex(`^\[(?P<Time>[^\]]+)\] (?P<Level>[A-Z]+) (?P<Content>.*)$`),
+		WithRejectWriter(&rejects),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for name, parse := range map[string]func(string) (*ParseResult, error){
+		"Parse":       func(s string) (*ParseResult, error) { return p.Parse(strings.NewReader(s)) },
+		"ParseStream": func(s string) (*ParseResult, error) { return p.ParseStream(strings.NewReader(s)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			rejects.Reset()
+			result, err := parse(input)
+			if err != nil {
+				t.Fatalf("%s() error = %v", name, err)
+			}
+			want := ParseStats{Lines: 4, HeaderMismatches: 2}
+			if result.Stats != want {
+				t.Errorf("Stats = %+v, want %+v", result.Stats, want)
+			}
+			if got, want := rejects.String(), "garbage without header\n[10:30:24] broken\n"; got != want {
+				t.Errorf("rejects = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseHeaderMismatchPolicy(t *testing.T) {
+	input := `2024-01-15 10:30:22 INFO: Task 1 finished
+Task 2 finished without header
+2024-01-15 10:30:24 INFO: Task 3 finished
+`
+	tests := []struct {
+		policy      HeaderMismatchPolicy
+		wantContent []string
+		wantLineIDs []int
+		wantSkipped int
+	}{
+		{
+			policy:      HeaderMismatchKeep,
+			wantContent: []string{"Task 1 finished", "finished without header", "Task 3 finished"},
+			wantLineIDs: []int{1, 2, 3},
+		},
+		{
+			policy:      HeaderMismatchWholeLine,
+			wantContent: []string{"Task 1 finished", "Task 2 finished without header", "Task 3 finished"},
+			wantLineIDs: []int{1, 2, 3},
+		},
+		{
+			policy:      HeaderMismatchSkip,
+			wantContent: []string{"Task 1 finished", "Task 3 finished"},
+			wantLineIDs: []int{1, 2},
+			wantSkipped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			p, err := New(
+				WithHeaderFormat("<Date> <Time> <Level>: <Content>"),
+				WithHeaderMismatch(tt.policy),
+			)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			result, err := p.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			var content []string
+			var lineIDs []int
+			for _, ev := range result.Events {
+				content = append(content, ev.RawContent)
+				lineIDs = append(lineIDs, ev.LineID)
+				if ev.HeaderMatched != (ev.StartLine != 2) {
+					t.Errorf("line %d HeaderMatched = %v", ev.StartLine, ev.HeaderMatched)
+				}
+			}
+			if !reflect.DeepEqual(content, tt.wantContent) {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if !reflect.DeepEqual(lineIDs, tt.wantLineIDs) {
+				t.Errorf("LineIDs = %v, want %v", lineIDs, tt.wantLineIDs)
+			}
+			want := ParseStats{Lines: 3, HeaderMismatches: 1, Skipped: tt.wantSkipped}
+			if result.Stats != want {
+				t.Errorf("Stats = %+v, want %+v", result.Stats, want)
+			}
+		})
+	}
+}
+
+func TestParseHeaderMismatchPolicyName(t *testing.T) {
+	for _, policy := range []HeaderMismatchPolicy{HeaderMismatchKeep, HeaderMismatchWholeLine, HeaderMismatchSkip} {
+		got, err := ParseHeaderMismatchPolicy(policy.String())
+		if err != nil || got != policy {
+			t.Errorf("ParseHeaderMismatchPolicy(%q) = %v, %v", policy.String(), got, err)
+		}
+	}
+	if _, err := ParseHeaderMismatchPolicy("strict"); err == nil {
+		t.Error("ParseHeaderMismatchPolicy(\"strict\") error = nil")
+	}
+	if _, err := New(WithHeaderMismatch(HeaderMismatchPolicy(7))); err == nil {
+		t.Error("New() error = nil for an unknown policy")
+	}
+}
+
+// cancelingReader produces an endless stream of log lines and cancels
+// its context after the given number of reads.
+type cancelingReader struct {
+	cancel context.CancelFunc
+	after  int
+	reads  int
+}
+
+func (r *cancelingReader) Read(b []byte) (int, error) {
+	r.reads++
+	if r.reads == r.after {
+		r.cancel()
+	}
+	line := "request " + intToStr(r.reads) + " completed\n"
+	return copy(b, line), nil
+}
+
+func TestParseContextCancelDuringRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, _ := New()
+	r := &cancelingReader{cancel: cancel, after: 100}
+
+	result, err := p.ParseContext(ctx, r)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ParseContext() error = %v, want context.Canceled", err)
+	}
+	if result != nil {
+		t.Errorf("ParseContext() result = %+v, want nil", result)
+	}
+	if r.reads > r.after+1 {
+		t.Errorf("reader kept being read after cancellation: %d reads", r.reads)
+	}
+}
+
+func TestParseContextCancelAfterRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		cancel func(Progress) bool // cancels once it returns true
+	}{
+		{"empty input", "", func(Progress) bool { return true }},
+		{"end of reading", "msg 1\nmsg 2\n", func(pr Progress) bool { return pr.LinesRead == 2 && pr.Groups == 0 }},
+		{"template generation", "msg 1\nmsg 2\nother 3\n", func(pr Progress) bool { return pr.Groups > 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.input == "" {
+				cancel()
+			}
+			p, _ := New(WithProgress(func(pr Progress) {
+				if tt.cancel(pr) {
+					cancel()
+				}
+			}))
+			result, err := p.ParseContext(ctx, strings.NewReader(tt.input))
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("ParseContext() error = %v, want context.Canceled", err)
+			}
+			if result != nil {
+				t.Errorf("ParseContext() result = %+v, want nil", result)
+			}
+		})
+	}
+}
+
+func TestParseContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	p, _ := New()
+	_, err := p.ParseContext(ctx, strings.NewReader("msg 1\nmsg 2\n"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ParseContext() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestGenerateTemplatesParallelCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var groups []*LogGroup
+	for i := 0; i < 50; i++ {
+		groups = append(groups, &LogGroup{
+			EventID: intToStr(i),
+			Events:  []*LogEvent{{TokenString: "msg " + intToStr(i)}},
+		})
+	}
+
+	p, _ := New(WithMaxWorkers(4))
+	if err := p.generateTemplatesParallel(ctx, groups, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("generateTemplatesParallel() error = %v, want context.Canceled", err)
+	}
+	for _, g := range groups {
+		if g.Template != "" {
+			t.Errorf("group %s was templated after cancellation", g.EventID)
+		}
+	}
+}
+
 // Benchmarks
 
 func BenchmarkParse12Lines(b *testing.B) {